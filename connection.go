@@ -1,7 +1,6 @@
 package star
 
 import (
-	"errors"
 	"fmt"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/mux"
@@ -13,6 +12,7 @@ import (
 	"github.com/pion/webrtc/v2"
 	"net"
 	"sync"
+	"time"
 )
 
 type connection struct {
@@ -22,11 +22,35 @@ type connection struct {
 	configuration  connectionConfiguration
 
 	dataChannelDetachedCh chan chan detachResult
+	datagramCh            chan DatagramConn
+	closedCh              chan struct{}
 	m                     sync.RWMutex
 	muxedConnection       mux.MuxedConn
+	mMuxedConnection      sync.Mutex
+
+	streams  int
+	draining bool
+
+	mRenegotiation sync.Mutex
+
+	recoveryTimer *time.Timer
+	idleTimer     *time.Timer
+	sctpTransport *webrtc.SCTPTransport
+	closeReason   error
+}
+
+type Conn interface {
+	transport.CapableConn
+
+	Stats() (ConnectionStats, error)
+
+	OpenDatagramChannel(label string, init *webrtc.DataChannelInit) (DatagramConn, error)
+	AcceptDatagramChannel() (DatagramConn, error)
+	OpenDataChannel(label string, init *webrtc.DataChannelInit) (DataChannel, error)
+	AddTrack(track *webrtc.Track) (*webrtc.RTPSender, error)
 }
 
-var _ transport.CapableConn = new(connection)
+var _ Conn = new(connection)
 
 var fakeNetAddress net.Addr
 
@@ -48,35 +72,76 @@ type connectionConfiguration struct {
 	transport   transport.Transport
 	multiplexer mux.Multiplexer
 
-	isServer bool
+	isServer         bool
+	dataChannelLabel string
+
+	// intentID identifies the handshake which established the connection, remoteFeatures are the protocol
+	// extensions the remote peer advertised in it.
+	intentID       string
+	remoteFeatures []string
+
+	stream streamConfiguration
+
+	iceRecoveryWindow time.Duration
+	idleTimeout       time.Duration
+	keepalive         KeepaliveConfiguration
+	metrics           MetricsSink
+	events            *eventEmitters
+
+	unregisterConnectionFunc func(c *connection)
+	dataChannelHandlerFunc   func(label string) DataChannelHandler
+	renegotiateFunc          func(c *connection, kind webrtc.RTPCodecType) error
 }
 
 type detachResult struct {
-	dataChannel datachannel.ReadWriteCloser
-	err         error
+	dataChannel   datachannel.ReadWriteCloser
+	sctpTransport *webrtc.SCTPTransport
+	err           error
 }
 
 func newConnection(configuration connectionConfiguration, peerConnection *webrtc.PeerConnection,
 	initChannel datachannel.ReadWriteCloser) *connection {
-	dataChannelDetachedCh := make(chan chan detachResult)
-	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
-		dataChannelDetachedCh <- detachDataChannel(dc)
-	})
-	return &connection{
+	c := &connection{
 		id:             createRandomID("connection"),
 		peerConnection: peerConnection,
 		configuration:  configuration,
 
-		dataChannelDetachedCh: dataChannelDetachedCh,
+		dataChannelDetachedCh: make(chan chan detachResult, 1),
+		datagramCh:            make(chan DatagramConn, datagramAcceptBacklog),
+		closedCh:              make(chan struct{}),
 		initChannel:           initChannel,
 	}
+	// pion handles incoming data channels one by one and opens them once the handler returns, so it must not
+	// block until the muxed connection is created.
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != configuration.dataChannelLabel {
+			c.handleIncomingDataChannel(dc)
+			return
+		}
+
+		select {
+		case c.dataChannelDetachedCh <- detachDataChannel(dc):
+		default:
+			logger.Warningf("%s: Ignore duplicate data channel (label: %s)", c.id, dc.Label())
+		}
+	})
+	peerConnection.OnICEConnectionStateChange(c.handleICEConnectionStateChange)
+
+	c.m.Lock()
+	c.startIdleTimer()
+	c.m.Unlock()
+	return c
 }
 
 func detachDataChannel(dataChannel *webrtc.DataChannel) chan detachResult {
-	detachedCh := make(chan detachResult)
+	detachedCh := make(chan detachResult, 1)
 	dataChannel.OnOpen(func() {
 		channel, err := dataChannel.Detach()
-		detachedCh <- detachResult{channel, err}
+		detachedCh <- detachResult{
+			dataChannel:   channel,
+			sctpTransport: dataChannel.Transport(),
+			err:           err,
+		}
 	})
 	return detachedCh
 }
@@ -84,53 +149,33 @@ func detachDataChannel(dataChannel *webrtc.DataChannel) chan detachResult {
 func (c *connection) OpenStream() (mux.MuxedStream, error) {
 	logger.Debugf("%s: Open stream", c.id)
 
+	c.m.RLock()
+	draining := c.draining
+	c.m.RUnlock()
+	if draining {
+		return nil, newOpError("open stream", c.configuration.remotePeerID, ErrTransportClosed, nil)
+	}
+
 	muxedConnection, err := c.getMuxedConnection()
 	if err != nil {
 		return nil, err
 	}
-	if muxedConnection != nil {
-		return muxedConnection.OpenStream()
-	}
-
-	rawDataChannel := c.checkInitChannel()
-	if rawDataChannel == nil {
-		pc, err := c.getPeerConnection()
-		if err != nil {
-			return nil, err
-		}
-		dc, err := pc.CreateDataChannel("data", nil)
-		if err != nil {
-			return nil, err
-		}
-
-		detached := <-detachDataChannel(dc)
-		if detached.err != nil {
-			return nil, detached.err
-		}
-		rawDataChannel = detached.dataChannel
-	}
-
-	return c.muxedConnection.OpenStream()
-}
 
-func (c *connection) checkInitChannel() datachannel.ReadWriteCloser {
-	c.m.Lock()
-	defer c.m.Unlock()
-	if c.initChannel != nil {
-		ch := c.initChannel
-		c.initChannel = nil
-		return ch
+	stream, err := muxedConnection.OpenStream()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return c.trackStream(stream), nil
 }
 
 func (c *connection) getPeerConnection() (*webrtc.PeerConnection, error) {
 	c.m.RLock()
 	pc := c.peerConnection
+	reason := c.closeReason
 	c.m.RUnlock()
 
 	if pc == nil {
-		return nil, errors.New("peer connection closed")
+		return nil, c.closedError(reason)
 	}
 	return pc, nil
 }
@@ -141,51 +186,83 @@ func (c *connection) AcceptStream() (mux.MuxedStream, error) {
 	if err != nil {
 		return nil, err
 	}
-	if muxedConnection != nil {
-		return muxedConnection.AcceptStream()
-	}
 
-	rawDataChannel := c.checkInitChannel()
-	if rawDataChannel == nil {
-		rawDataChannel, err = c.awaitDataChannelDetached()
+	stream, err := muxedConnection.AcceptStream()
+	if err != nil {
+		return nil, err
 	}
-	return c.muxedConnection.AcceptStream()
+	return c.trackStream(stream), nil
 }
 
+// getMuxedConnection creates the muxed connection on first use. Waiting for the data channel doesn't hold c.m,
+// so the connection can be closed meanwhile.
 func (c *connection) getMuxedConnection() (mux.MuxedConn, error) {
-	c.m.Lock()
-	defer c.m.Unlock()
+	c.mMuxedConnection.Lock()
+	defer c.mMuxedConnection.Unlock()
 
-	if c.muxedConnection != nil {
-		return c.muxedConnection, nil
+	c.m.RLock()
+	muxedConnection := c.muxedConnection
+	rawDataChannel := c.initChannel
+	closed := c.peerConnection == nil
+	reason := c.closeReason
+	c.m.RUnlock()
+
+	if closed {
+		return nil, c.closedError(reason)
+	}
+	if muxedConnection != nil {
+		return muxedConnection, nil
 	}
 
-	rawDataChannel := c.initChannel
 	if rawDataChannel == nil {
-		var err error
-		rawDataChannel, err = c.awaitDataChannelDetached()
-		if err != nil {
-			return nil, err
+		detached := c.awaitDataChannelDetached()
+		if detached.err != nil {
+			return nil, detached.err
+		}
+		c.m.Lock()
+		if c.sctpTransport == nil {
+			c.sctpTransport = detached.sctpTransport
 		}
+		c.m.Unlock()
+		rawDataChannel = detached.dataChannel
 	}
 
-	var err error
-	c.muxedConnection, err = c.configuration.multiplexer.NewConn(newStream(rawDataChannel, fakeNetAddress),
-		c.configuration.isServer)
+	stream := newStream(rawDataChannel, fakeNetAddress, c.configuration.stream, c.configuration.metrics)
+	muxedConnection, err := c.configuration.multiplexer.NewConn(stream, c.configuration.isServer)
 	if err != nil {
 		return nil, err
 	}
-	return c.muxedConnection, nil
+
+	c.m.Lock()
+	if c.peerConnection == nil {
+		reason := c.closeReason
+		c.m.Unlock()
+
+		muxedConnection.Close()
+		return nil, c.closedError(reason)
+	}
+	c.muxedConnection = muxedConnection
+	c.m.Unlock()
+
+	go c.runKeepalive(stream)
+	return muxedConnection, nil
 }
 
-func (c *connection) awaitDataChannelDetached() (datachannel.ReadWriteCloser, error) {
-	detachedCh, ok := <-c.dataChannelDetachedCh
-	if !ok {
-		return nil, errors.New("connection closed")
+func (c *connection) awaitDataChannelDetached() detachResult {
+	var detachedCh chan detachResult
+	select {
+	case detachedCh = <-c.dataChannelDetachedCh:
+	case <-c.closedCh:
+		// closeReason is written before closedCh is closed
+		return detachResult{err: c.closedError(c.closeReason)}
 	}
 
-	detached := <-detachedCh
-	return detached.dataChannel, detached.err
+	select {
+	case detached := <-detachedCh:
+		return detached
+	case <-c.closedCh:
+		return detachResult{err: c.closedError(c.closeReason)}
+	}
 }
 
 func (c *connection) IsClosed() bool {
@@ -196,17 +273,87 @@ func (c *connection) IsClosed() bool {
 }
 
 func (c *connection) Close() error {
-	logger.Debugf("%s: Close connection (no actions)", c.id)
+	logger.Debugf("%s: Close connection", c.id)
+
+	closed, err := c.closePeerConnection()
+	if closed && c.configuration.unregisterConnectionFunc != nil {
+		c.configuration.unregisterConnectionFunc(c)
+	}
+	return err
+}
+
+// closeWithReason closes the connection, so that following operations fail with the reason.
+func (c *connection) closeWithReason(reason error) {
+	c.m.Lock()
+	if c.peerConnection != nil && c.closeReason == nil {
+		c.closeReason = reason
+	}
+	c.m.Unlock()
+
+	err := c.Close()
+	if err != nil {
+		logger.Errorf("%s: Can't close connection: %v", c.id, err)
+	}
+}
+
+func (c *connection) drain() {
 	c.m.Lock()
 	defer c.m.Unlock()
+	c.draining = true
+}
 
-	var err error
-	if c.peerConnection != nil {
-		err = c.peerConnection.Close()
+func (c *connection) closedError(reason error) error {
+	if reason == nil {
+		reason = ErrConnectionClosed
 	}
+	return newOpError("connection", c.configuration.remotePeerID, reason, nil)
+}
+
+func (c *connection) closePeerConnection() (bool, error) {
+	c.m.Lock()
+	if c.peerConnection == nil {
+		c.m.Unlock()
+		return false, nil
+	}
+
+	c.stopRecoveryTimer()
+	c.stopIdleTimer()
+	decrementMetric(c.configuration.metrics, MetricPeerConnectionsActive)
+	peerConnection := c.peerConnection
+	muxedConnection := c.muxedConnection
 	c.peerConnection = nil
-	close(c.dataChannelDetachedCh)
-	return err
+	close(c.closedCh)
+	c.m.Unlock()
+
+	// The muxer waits for its reader, which is released once the peer connection is closed.
+	err := peerConnection.Close()
+	if muxedConnection != nil {
+		muxErr := muxedConnection.Close()
+		if muxErr != nil {
+			logger.Debugf("%s: Can't close muxed connection: %v", c.id, muxErr)
+		}
+	}
+	return true, err
+}
+
+// discard marks a connection, which has never been handed out, as closed. Unlike Close it leaves the peer
+// connection open, it's still owned by the signal.
+func (c *connection) discard(reason error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.peerConnection == nil {
+		return
+	}
+	c.stopRecoveryTimer()
+	c.stopIdleTimer()
+	c.peerConnection = nil
+	c.closeReason = reason
+	close(c.closedCh)
+}
+
+func (c *connection) remoteSupports(feature string) bool {
+	return containsString(c.configuration.remoteFeatures, feature)
 }
 
 func (c *connection) LocalPeer() peer.ID {
@@ -0,0 +1,39 @@
+package star
+
+import "time"
+
+// startIdleTimer closes the connection once it had no open streams for the idle timeout. c.m must be held.
+func (c *connection) startIdleTimer() {
+	timeout := c.configuration.idleTimeout
+	if timeout <= 0 || c.peerConnection == nil || c.idleTimer != nil {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		c.m.Lock()
+		if c.idleTimer != timer { // stopped or replaced meanwhile
+			c.m.Unlock()
+			return
+		}
+		c.idleTimer = nil
+		idle := c.peerConnection != nil && c.streams == 0
+		c.m.Unlock()
+
+		if idle {
+			logger.Infof("%s: No open streams for %v. Closing", c.id, timeout)
+			c.closeWithReason(ErrConnectionIdle)
+		}
+	})
+	c.idleTimer = timer
+}
+
+// stopIdleTimer must be called with c.m held.
+func (c *connection) stopIdleTimer() {
+	if c.idleTimer == nil {
+		return
+	}
+
+	c.idleTimer.Stop()
+	c.idleTimer = nil
+}
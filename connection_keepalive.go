@@ -0,0 +1,63 @@
+package star
+
+import "time"
+
+const (
+	keepalivePing = "ping"
+	keepalivePong = "pong"
+)
+
+// KeepaliveConfiguration enables keepalives on the data channel carrying the muxed connection. They detect
+// a remote peer which vanished while ICE still considers the connection alive.
+//
+// Keepalives are text messages, the muxer only sends binary ones. Every peer advertising keepalives in the
+// handshake answers them, even without keepalives enabled. Other peers, e.g. js-libp2p-webrtc-star, would pass
+// them to their muxer, so they aren't pinged and only their closed connections are detected.
+type KeepaliveConfiguration struct {
+	// Interval between keepalives sent while nothing is received (default: 0, keepalives are disabled).
+	Interval time.Duration
+
+	// Timeout closes the connection if nothing is received for this long (default: 3 * Interval).
+	Timeout time.Duration
+}
+
+func (c KeepaliveConfiguration) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 3 * c.Interval
+	}
+	return c.Timeout
+}
+
+// runKeepalive pings the remote peer while the connection is silent and closes it once the timeout expires.
+func (c *connection) runKeepalive(s *stream) {
+	interval := c.configuration.keepalive.Interval
+	if interval <= 0 || !c.configuration.stream.keepalive {
+		return
+	}
+	timeout := c.configuration.keepalive.timeout()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.closedCh:
+			return
+		}
+
+		silence := s.sinceReceived()
+		if silence >= timeout {
+			logger.Warningf("%s: Nothing received from the remote peer for %v. Closing", c.id, silence)
+			c.closeWithReason(ErrPeerUnresponsive)
+			return
+		}
+
+		if silence >= interval {
+			err := s.ping()
+			if err != nil {
+				logger.Debugf("%s: Can't send keepalive: %v", c.id, err)
+			}
+		}
+	}
+}
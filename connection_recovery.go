@@ -0,0 +1,100 @@
+package star
+
+import (
+	"github.com/pion/webrtc/v2"
+	"time"
+)
+
+const defaultICERecoveryWindow = 15 * time.Second
+
+// Recovery relies on the ICE agent selecting a working candidate pair again before the window expires. A renegotiation
+// can't restart ICE in pion/webrtc v2.2: CreateOffer rejects OfferOptions, so ICERestart can't be set, and a
+// renegotiated remote description is applied without its ICE credentials and candidates.
+func (c *connection) handleICEConnectionStateChange(state webrtc.ICEConnectionState) {
+	logger.Debugf("%s: ICE connection state changed: %s", c.id, state)
+	c.configuration.events.emit(EvtConnectionStateChanged{
+		Peer:            c.configuration.remotePeerID,
+		RemoteMultiaddr: c.configuration.remotePeerMultiaddr,
+		State:           state,
+	})
+
+	switch state {
+	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		c.m.Lock()
+		recovered := c.stopRecoveryTimer()
+		c.m.Unlock()
+
+		if recovered {
+			logger.Infof("%s: ICE connection recovered", c.id)
+		}
+	case webrtc.ICEConnectionStateDisconnected:
+		c.startRecovery()
+	case webrtc.ICEConnectionStateFailed:
+		logger.Warningf("%s: ICE connection failed. Closing", c.id)
+		c.closeUnrecoverable()
+	}
+}
+
+func (c *connection) startRecovery() {
+	window := c.configuration.iceRecoveryWindow
+	if window <= 0 {
+		logger.Warningf("%s: ICE connection lost and recovery is disabled. Closing", c.id)
+		c.closeUnrecoverable()
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.peerConnection == nil || c.recoveryTimer != nil {
+		return
+	}
+
+	logger.Infof("%s: ICE connection lost. Wait %v for recovery", c.id, window)
+	var timer *time.Timer
+	timer = time.AfterFunc(window, func() {
+		c.finishRecovery(&timer)
+	})
+	c.recoveryTimer = timer
+}
+
+// finishRecovery closes the connection if ICE hasn't recovered once the timer fired. A timer stopped while its
+// callback was already waiting for the lock belongs to an earlier disconnection, so it leaves the current one
+// alone. The timer is read under the lock, it's assigned after AfterFunc returns.
+func (c *connection) finishRecovery(timer **time.Timer) {
+	c.m.Lock()
+	if c.recoveryTimer != *timer {
+		c.m.Unlock()
+		return
+	}
+	c.recoveryTimer = nil
+	pc := c.peerConnection
+	c.m.Unlock()
+
+	if pc == nil {
+		return
+	}
+
+	state := pc.ICEConnectionState()
+	if state == webrtc.ICEConnectionStateConnected || state == webrtc.ICEConnectionStateCompleted {
+		return
+	}
+
+	logger.Warningf("%s: ICE connection not recovered within %v (state: %s). Closing", c.id,
+		c.configuration.iceRecoveryWindow, state)
+	c.closeUnrecoverable()
+}
+
+func (c *connection) stopRecoveryTimer() bool {
+	if c.recoveryTimer == nil {
+		return false
+	}
+
+	c.recoveryTimer.Stop()
+	c.recoveryTimer = nil
+	return true
+}
+
+func (c *connection) closeUnrecoverable() {
+	c.closeWithReason(ErrICEFailed)
+}
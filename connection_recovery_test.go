@@ -0,0 +1,70 @@
+package star
+
+import (
+	"errors"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const testICERecoveryWindow = 200 * time.Millisecond
+
+// newTestRecoveringConnection creates a connection over an unconnected peer connection, so its ICE state changes
+// are driven by the test.
+func newTestRecoveringConnection(t *testing.T) *connection {
+	peerConnection, err := webrtcapi.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	return newConnection(connectionConfiguration{
+		iceRecoveryWindow: testICERecoveryWindow,
+		metrics:           noopMetricsSink{},
+	}, peerConnection, nil)
+}
+
+func TestConnectionSurvivesRecoveredICE(t *testing.T) {
+	c := newTestRecoveringConnection(t)
+	defer c.Close()
+
+	// when
+	c.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	c.handleICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	time.Sleep(2 * testICERecoveryWindow)
+
+	// then
+	assert.False(t, c.IsClosed())
+}
+
+func TestConnectionClosesUnrecoveredICE(t *testing.T) {
+	c := newTestRecoveringConnection(t)
+
+	// when
+	c.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+
+	// then
+	require.Eventually(t, c.IsClosed, 5*testICERecoveryWindow, 10*time.Millisecond)
+	_, err := c.getPeerConnection()
+	assert.True(t, errors.Is(err, ErrICEFailed), "unexpected error: %v", err)
+}
+
+func TestConnectionIgnoresStaleRecoveryTimer(t *testing.T) {
+	c := newTestRecoveringConnection(t)
+	defer c.Close()
+
+	c.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	c.m.Lock()
+	staleTimer := c.recoveryTimer
+	c.m.Unlock()
+	c.handleICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	c.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+
+	// when
+	c.finishRecovery(&staleTimer) // fired while the connection recovered
+
+	// then
+	assert.False(t, c.IsClosed())
+	c.m.Lock()
+	assert.NotNil(t, c.recoveryTimer)
+	c.m.Unlock()
+}
@@ -0,0 +1,130 @@
+package star
+
+import (
+	"github.com/libp2p/go-libp2p-core/mux"
+	"sync"
+)
+
+// connectionShares counts the references to a connection returned to coalesced dials. The connection is closed
+// once every reference is released.
+type connectionShares struct {
+	connection *connection
+
+	m    sync.Mutex
+	refs int
+
+	acceptOnce sync.Once
+	streamCh   chan mux.MuxedStream
+	acceptErr  error
+	acceptedCh chan struct{}
+}
+
+func newConnectionShares(c *connection, refs int) *connectionShares {
+	return &connectionShares{
+		connection: c,
+		refs:       refs,
+		streamCh:   make(chan mux.MuxedStream),
+		acceptedCh: make(chan struct{}),
+	}
+}
+
+func (s *connectionShares) share() *sharedConn {
+	return &sharedConn{
+		connection: s.connection,
+		shares:     s,
+		closedCh:   make(chan struct{}),
+	}
+}
+
+func (s *connectionShares) release() error {
+	s.m.Lock()
+	s.refs--
+	last := s.refs == 0
+	s.m.Unlock()
+
+	if last {
+		return s.connection.Close()
+	}
+	return nil
+}
+
+// acceptStreams passes streams opened by the remote peer to whichever reference accepts first, as every
+// reference may be accepting at the same time.
+func (s *connectionShares) acceptStreams() {
+	defer close(s.acceptedCh)
+
+	for {
+		stream, err := s.connection.AcceptStream()
+		if err != nil {
+			s.acceptErr = err
+			return
+		}
+
+		select {
+		case s.streamCh <- stream:
+		case <-s.connection.closedCh:
+			stream.Reset()
+			return
+		}
+	}
+}
+
+// sharedConn is a reference to a connection shared by coalesced dials. Closing it only closes the connection
+// once no other reference is left, so a caller closing its reference doesn't close it under the others.
+type sharedConn struct {
+	*connection
+
+	shares    *connectionShares
+	closedCh  chan struct{}
+	closeOnce sync.Once
+}
+
+var _ Conn = new(sharedConn)
+
+func (s *sharedConn) OpenStream() (mux.MuxedStream, error) {
+	if s.isReleased() {
+		return nil, s.closedError(nil)
+	}
+	return s.connection.OpenStream()
+}
+
+func (s *sharedConn) AcceptStream() (mux.MuxedStream, error) {
+	s.shares.acceptOnce.Do(func() {
+		go s.shares.acceptStreams()
+	})
+
+	select {
+	case stream := <-s.shares.streamCh:
+		return stream, nil
+	case <-s.shares.acceptedCh:
+		// acceptErr is written before acceptedCh is closed
+		if s.shares.acceptErr != nil {
+			return nil, s.shares.acceptErr
+		}
+		return nil, s.closedError(s.closeReason)
+	case <-s.closedCh:
+		return nil, s.closedError(nil)
+	}
+}
+
+func (s *sharedConn) IsClosed() bool {
+	return s.isReleased() || s.connection.IsClosed()
+}
+
+func (s *sharedConn) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closedCh)
+		err = s.shares.release()
+	})
+	return err
+}
+
+func (s *sharedConn) isReleased() bool {
+	select {
+	case <-s.closedCh:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,165 @@
+package star
+
+import (
+	"github.com/pion/webrtc/v2"
+	"sort"
+	"time"
+)
+
+const sctpTransportStatsID = "sctpTransport"
+
+type ConnectionStats struct {
+	Timestamp time.Time
+
+	ICEConnectionState    webrtc.ICEConnectionState
+	DTLSState             webrtc.DTLSTransportState
+	SelectedCandidatePair *CandidatePairStats
+
+	BytesSent     uint64
+	BytesReceived uint64
+
+	DataChannels []DataChannelStats
+}
+
+type CandidatePairStats struct {
+	Local  CandidateStats
+	Remote CandidateStats
+
+	State                webrtc.StatsICECandidatePairState
+	CurrentRoundTripTime time.Duration
+	TotalRoundTripTime   time.Duration
+
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+type CandidateStats struct {
+	CandidateType webrtc.ICECandidateType
+	NetworkType   webrtc.NetworkType
+	Protocol      string
+	IP            string
+	Port          int32
+	RelayProtocol string
+}
+
+type DataChannelStats struct {
+	ID       int32
+	Label    string
+	Protocol string
+	State    webrtc.DataChannelState
+
+	MessagesSent     uint32
+	MessagesReceived uint32
+	BytesSent        uint64
+	BytesReceived    uint64
+}
+
+func (c *connection) Stats() (ConnectionStats, error) {
+	pc, err := c.getPeerConnection()
+	if err != nil {
+		return ConnectionStats{}, err
+	}
+
+	c.m.RLock()
+	sctpTransport := c.sctpTransport
+	c.m.RUnlock()
+
+	dtlsState := webrtc.DTLSTransportStateNew
+	if sctpTransport != nil && sctpTransport.Transport() != nil {
+		dtlsState = sctpTransport.Transport().State()
+	}
+
+	stats := buildConnectionStats(pc.GetStats())
+	stats.ICEConnectionState = pc.ICEConnectionState()
+	stats.DTLSState = dtlsState
+	return stats, nil
+}
+
+func (c *connection) observeSCTPTransport(sctpTransport *webrtc.SCTPTransport) {
+	if sctpTransport == nil {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.sctpTransport == nil {
+		c.sctpTransport = sctpTransport
+	}
+}
+
+func buildConnectionStats(report webrtc.StatsReport) ConnectionStats {
+	stats := ConnectionStats{
+		Timestamp: time.Now(),
+	}
+
+	var selectedPair *webrtc.ICECandidatePairStats
+	for _, s := range report {
+		switch v := s.(type) {
+		case webrtc.TransportStats:
+			if v.ID == sctpTransportStatsID {
+				stats.BytesSent = v.BytesSent
+				stats.BytesReceived = v.BytesReceived
+			}
+		case webrtc.DataChannelStats:
+			stats.DataChannels = append(stats.DataChannels, DataChannelStats{
+				ID:               v.DataChannelIdentifier,
+				Label:            v.Label,
+				Protocol:         v.Protocol,
+				State:            v.State,
+				MessagesSent:     v.MessagesSent,
+				MessagesReceived: v.MessagesReceived,
+				BytesSent:        v.BytesSent,
+				BytesReceived:    v.BytesReceived,
+			})
+		case webrtc.ICECandidatePairStats:
+			if isSelectedCandidatePair(v, selectedPair) {
+				pair := v
+				selectedPair = &pair
+			}
+		}
+	}
+
+	sort.Slice(stats.DataChannels, func(i, j int) bool {
+		return stats.DataChannels[i].ID < stats.DataChannels[j].ID
+	})
+
+	if selectedPair != nil {
+		stats.SelectedCandidatePair = &CandidatePairStats{
+			Local:                buildCandidateStats(report, selectedPair.LocalCandidateID),
+			Remote:               buildCandidateStats(report, selectedPair.RemoteCandidateID),
+			State:                selectedPair.State,
+			CurrentRoundTripTime: secondsToDuration(selectedPair.CurrentRoundTripTime),
+			TotalRoundTripTime:   secondsToDuration(selectedPair.TotalRoundTripTime),
+			BytesSent:            selectedPair.BytesSent,
+			BytesReceived:        selectedPair.BytesReceived,
+		}
+	}
+	return stats
+}
+
+func isSelectedCandidatePair(candidate webrtc.ICECandidatePairStats, selected *webrtc.ICECandidatePairStats) bool {
+	if !candidate.Nominated || candidate.State != webrtc.StatsICECandidatePairStateSucceeded {
+		return false
+	}
+	return selected == nil || candidate.LastPacketReceivedTimestamp > selected.LastPacketReceivedTimestamp
+}
+
+func buildCandidateStats(report webrtc.StatsReport, candidateID string) CandidateStats {
+	candidate, ok := report[candidateID].(webrtc.ICECandidateStats)
+	if !ok {
+		return CandidateStats{}
+	}
+	return CandidateStats{
+		CandidateType: candidate.CandidateType,
+		NetworkType:   candidate.NetworkType,
+		Protocol:      candidate.Protocol,
+		IP:            candidate.IP,
+		Port:          candidate.Port,
+		RelayProtocol: candidate.RelayProtocol,
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
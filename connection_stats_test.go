@@ -0,0 +1,127 @@
+package star
+
+import (
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBuildConnectionStats(t *testing.T) {
+	report := webrtc.StatsReport{
+		"sctpTransport": webrtc.TransportStats{
+			ID:            "sctpTransport",
+			BytesSent:     1024,
+			BytesReceived: 2048,
+		},
+		"DataChannel-1": webrtc.DataChannelStats{
+			ID:                    "DataChannel-1",
+			Label:                 "data",
+			DataChannelIdentifier: 1,
+			State:                 webrtc.DataChannelStateOpen,
+			MessagesSent:          3,
+			BytesSent:             300,
+		},
+		"DataChannel-0": webrtc.DataChannelStats{
+			ID:                    "DataChannel-0",
+			Label:                 "data",
+			DataChannelIdentifier: 0,
+			State:                 webrtc.DataChannelStateOpen,
+			MessagesReceived:      5,
+			BytesReceived:         500,
+		},
+		"local-srflx": webrtc.ICECandidateStats{
+			ID:            "local-srflx",
+			Type:          webrtc.StatsTypeLocalCandidate,
+			CandidateType: webrtc.ICECandidateTypeSrflx,
+			IP:            "1.2.3.4",
+			Port:          50000,
+		},
+		"remote-relay": webrtc.ICECandidateStats{
+			ID:            "remote-relay",
+			Type:          webrtc.StatsTypeRemoteCandidate,
+			CandidateType: webrtc.ICECandidateTypeRelay,
+			IP:            "5.6.7.8",
+			Port:          3478,
+		},
+		"pair-failed": webrtc.ICECandidatePairStats{
+			ID:                "pair-failed",
+			LocalCandidateID:  "local-host",
+			RemoteCandidateID: "remote-host",
+			State:             webrtc.StatsICECandidatePairStateFailed,
+		},
+		"pair-selected": webrtc.ICECandidatePairStats{
+			ID:                   "pair-selected",
+			LocalCandidateID:     "local-srflx",
+			RemoteCandidateID:    "remote-relay",
+			State:                webrtc.StatsICECandidatePairStateSucceeded,
+			Nominated:            true,
+			CurrentRoundTripTime: 0.25,
+			BytesSent:            4096,
+		},
+	}
+
+	// when
+	stats := buildConnectionStats(report)
+
+	// then
+	assert.Equal(t, uint64(1024), stats.BytesSent)
+	assert.Equal(t, uint64(2048), stats.BytesReceived)
+
+	require.Len(t, stats.DataChannels, 2)
+	assert.Equal(t, int32(0), stats.DataChannels[0].ID)
+	assert.Equal(t, uint64(500), stats.DataChannels[0].BytesReceived)
+	assert.Equal(t, uint32(3), stats.DataChannels[1].MessagesSent)
+
+	require.NotNil(t, stats.SelectedCandidatePair)
+	assert.Equal(t, webrtc.ICECandidateTypeSrflx, stats.SelectedCandidatePair.Local.CandidateType)
+	assert.Equal(t, webrtc.ICECandidateTypeRelay, stats.SelectedCandidatePair.Remote.CandidateType)
+	assert.Equal(t, "5.6.7.8", stats.SelectedCandidatePair.Remote.IP)
+	assert.Equal(t, 250*time.Millisecond, stats.SelectedCandidatePair.CurrentRoundTripTime)
+	assert.Equal(t, uint64(4096), stats.SelectedCandidatePair.BytesSent)
+}
+
+func TestBuildConnectionStatsWithoutSelectedPair(t *testing.T) {
+	// when
+	stats := buildConnectionStats(webrtc.StatsReport{})
+
+	// then
+	assert.Nil(t, stats.SelectedCandidatePair)
+	assert.Empty(t, stats.DataChannels)
+}
+
+func TestConnectionStats(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+	stream, err := dialed.OpenStream()
+	require.NoError(t, err)
+	_, err = stream.Write(make([]byte, 1024))
+	require.NoError(t, err)
+
+	remoteStream, err := accepted.AcceptStream()
+	require.NoError(t, err)
+	_, err = io.ReadFull(remoteStream, make([]byte, 1024))
+	require.NoError(t, err)
+
+	// when
+	stats, err := dialed.(Conn).Stats()
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, webrtc.ICEConnectionStateConnected, stats.ICEConnectionState)
+	assert.Equal(t, webrtc.DTLSTransportStateConnected, stats.DTLSState)
+	assert.True(t, stats.BytesSent >= 1024, "bytes sent: %d", stats.BytesSent)
+	assert.NotZero(t, stats.BytesReceived)
+	assert.NotNil(t, stats.SelectedCandidatePair)
+	require.NotEmpty(t, stats.DataChannels)
+	assert.NotZero(t, stats.DataChannels[0].BytesSent)
+}
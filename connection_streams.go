@@ -0,0 +1,83 @@
+package star
+
+import (
+	"github.com/libp2p/go-libp2p-core/mux"
+	"net"
+	"sync"
+)
+
+// trackedStream counts open streams, so Drain can wait for them. A stream is finished once it's closed or reset,
+// either locally or by the remote peer. The muxer doesn't report remote closes, so they're noticed by a failing
+// Read or Write, e.g. io.EOF or a reset error.
+type trackedStream struct {
+	mux.MuxedStream
+
+	connection *connection
+	doneOnce   sync.Once
+}
+
+func (c *connection) trackStream(stream mux.MuxedStream) mux.MuxedStream {
+	c.m.Lock()
+	c.streams++
+	c.stopIdleTimer()
+	c.m.Unlock()
+
+	return &trackedStream{
+		MuxedStream: stream,
+		connection:  c,
+	}
+}
+
+func (c *connection) activeStreams() int {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if c.peerConnection == nil {
+		return 0
+	}
+	return c.streams
+}
+
+func (s *trackedStream) Read(p []byte) (int, error) {
+	n, err := s.MuxedStream.Read(p)
+	s.doneOnError(err)
+	return n, err
+}
+
+func (s *trackedStream) Write(p []byte) (int, error) {
+	n, err := s.MuxedStream.Write(p)
+	s.doneOnError(err)
+	return n, err
+}
+
+func (s *trackedStream) Close() error {
+	defer s.done()
+	return s.MuxedStream.Close()
+}
+
+func (s *trackedStream) Reset() error {
+	defer s.done()
+	return s.MuxedStream.Reset()
+}
+
+// doneOnError finishes the stream unless err is nil or an expired deadline, after which the stream is still usable.
+func (s *trackedStream) doneOnError(err error) {
+	if err == nil {
+		return
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return
+	}
+	s.done()
+}
+
+func (s *trackedStream) done() {
+	s.doneOnce.Do(func() {
+		s.connection.m.Lock()
+		s.connection.streams--
+		if s.connection.streams == 0 {
+			s.connection.startIdleTimer()
+		}
+		s.connection.m.Unlock()
+	})
+}
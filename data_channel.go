@@ -0,0 +1,171 @@
+package star
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pion/webrtc/v2"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDataChannelLabel = "data"
+
+	// sctpMaxMessageSize is the largest message pion/sctp accepts.
+	sctpMaxMessageSize = math.MaxUint16
+	// defaultRemoteMaxMessageSize applies when the remote description has no a=max-message-size (RFC 8841).
+	defaultRemoteMaxMessageSize = 65536
+
+	defaultMaxBufferedAmount = 1 << 20
+)
+
+var (
+	errNegotiatedDataChannel = errors.New("negotiated data channels aren't supported")
+	errUnreliableDataChannel = errors.New("the muxed connection needs a reliable, ordered data channel")
+)
+
+// DataChannelConfiguration describes the data channel carrying the muxed connection.
+type DataChannelConfiguration struct {
+	// Label of the channel (default: "data"). Both peers have to use the same label, channels with other labels
+	// are passed to data channel handlers or accepted as datagram channels.
+	Label string
+
+	// Init is passed to CreateDataChannel, e.g. to set Protocol. The stream muxer needs reliable, in-order
+	// delivery, so unordered channels and channels with MaxRetransmits or MaxPacketLifeTime are rejected; data
+	// channels opened with Conn.OpenDataChannel and Conn.OpenDatagramChannel may use them.
+	Init webrtc.DataChannelInit
+
+	// MaxMessageSize limits the size of a single message (default and maximum: 65535 bytes, the limit of
+	// pion/sctp). Writes are split into messages no larger than this or a=max-message-size of the remote peer.
+	MaxMessageSize int
+
+	// ReceiveBufferSize is the largest message the remote peer may send (default: 65535 bytes). It's advertised
+	// as a=max-message-size in the local session description. The SCTP association buffer itself is fixed by
+	// pion/sctp.
+	ReceiveBufferSize int
+
+	// MaxBufferedAmount is the high-water mark of data queued for sending (default: 1 MiB). Writes block once
+	// it's reached, until the buffered amount drops below BufferedAmountLowThreshold (default: half of
+	// MaxBufferedAmount) or the write deadline expires.
+	MaxBufferedAmount uint64
+
+	// BufferedAmountLowThreshold is the low-water mark at which blocked writes resume.
+	BufferedAmountLowThreshold uint64
+}
+
+func (c DataChannelConfiguration) label() string {
+	if c.Label == "" {
+		return defaultDataChannelLabel
+	}
+	return c.Label
+}
+
+func (c DataChannelConfiguration) receiveBufferSize() int {
+	if c.ReceiveBufferSize <= 0 {
+		return sctpMaxMessageSize
+	}
+	return c.ReceiveBufferSize
+}
+
+func (c DataChannelConfiguration) maxMessageSize() int {
+	if c.MaxMessageSize <= 0 || c.MaxMessageSize > sctpMaxMessageSize {
+		return sctpMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
+
+// streamConfiguration applies the defaults and the message size negotiated with the remote peer.
+func (c DataChannelConfiguration) streamConfiguration(
+	remoteDescription *webrtc.SessionDescription) streamConfiguration {
+	configuration := streamConfiguration{
+		maxMessageSize:             negotiateMaxMessageSize(c.maxMessageSize(), remoteDescription),
+		receiveBufferSize:          c.receiveBufferSize(),
+		maxBufferedAmount:          c.MaxBufferedAmount,
+		bufferedAmountLowThreshold: c.BufferedAmountLowThreshold,
+	}
+	if configuration.maxBufferedAmount == 0 {
+		configuration.maxBufferedAmount = defaultMaxBufferedAmount
+	}
+	if configuration.bufferedAmountLowThreshold == 0 ||
+		configuration.bufferedAmountLowThreshold > configuration.maxBufferedAmount {
+		configuration.bufferedAmountLowThreshold = configuration.maxBufferedAmount / 2
+	}
+	return configuration
+}
+
+// validateDataChannelInit rejects negotiated channels and fixed IDs. pion/webrtc opens a negotiated channel only
+// once the SCTP association is up, and data arriving before that stops the remote peer from accepting any
+// channel. Announced channels don't cost a round trip either, pion sends data right after the DCEP OPEN.
+func validateDataChannelInit(init *webrtc.DataChannelInit) error {
+	if (init.Negotiated != nil && *init.Negotiated) || init.ID != nil {
+		return errNegotiatedDataChannel
+	}
+	return nil
+}
+
+// validateSessionDataChannelInit also requires the reliable, in-order delivery the stream muxer relies on.
+func validateSessionDataChannelInit(init *webrtc.DataChannelInit) error {
+	if (init.Ordered != nil && !*init.Ordered) || init.MaxRetransmits != nil || init.MaxPacketLifeTime != nil {
+		return errUnreliableDataChannel
+	}
+	return validateDataChannelInit(init)
+}
+
+// negotiateMaxMessageSize respects a=max-message-size of the remote description, 0 stands for no limit.
+func negotiateMaxMessageSize(localMaxMessageSize int, remoteDescription *webrtc.SessionDescription) int {
+	remoteMaxMessageSize := defaultRemoteMaxMessageSize
+	if remoteDescription != nil {
+		remoteMaxMessageSize = readMaxMessageSize(remoteDescription.SDP)
+	}
+
+	if remoteMaxMessageSize > 0 && remoteMaxMessageSize < localMaxMessageSize {
+		return remoteMaxMessageSize
+	}
+	return localMaxMessageSize
+}
+
+// setMaxMessageSize advertises the largest message the local peer receives in the application section.
+func setMaxMessageSize(sdp string, size int) string {
+	attribute := fmt.Sprintf("a=max-message-size:%d\r\n", size)
+
+	var b strings.Builder
+	inApplication := false
+	for _, line := range strings.SplitAfter(sdp, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "m=") {
+			if inApplication {
+				b.WriteString(attribute)
+			}
+			inApplication = strings.HasPrefix(trimmed, "m=application")
+		}
+		if inApplication && (trimmed == "" || strings.HasPrefix(trimmed, "a=max-message-size:")) {
+			continue
+		}
+		b.WriteString(line)
+	}
+	if inApplication {
+		if !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(attribute)
+	}
+	return b.String()
+}
+
+func readMaxMessageSize(sdp string) int {
+	for _, line := range strings.Split(sdp, "\n") {
+		value := strings.TrimPrefix(strings.TrimSpace(line), "a=max-message-size:")
+		if len(value) == len(strings.TrimSpace(line)) {
+			continue
+		}
+
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			logger.Warningf("Invalid max-message-size attribute: %s", line)
+			break
+		}
+		return size
+	}
+	return defaultRemoteMaxMessageSize
+}
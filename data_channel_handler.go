@@ -0,0 +1,202 @@
+package star
+
+import (
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v2"
+	"io"
+	"sync"
+)
+
+// DataChannel is a data channel with a label chosen by the application, e.g. "chat" to talk to a browser app
+// using plain WebRTC. It's not muxed: every Write sends a single message and every Read returns a single message.
+// ReadDataChannel and WriteDataChannel tell text messages apart from binary ones.
+type DataChannel interface {
+	datachannel.ReadWriteCloser
+
+	Label() string
+}
+
+// DataChannelHandler is called in its own goroutine for every data channel with a matching label, which is
+// opened by the remote peer.
+type DataChannelHandler func(conn Conn, dataChannel DataChannel)
+
+type rawDataChannel struct {
+	datachannel.ReadWriteCloser
+
+	label string
+}
+
+var _ DataChannel = new(rawDataChannel)
+
+func (d *rawDataChannel) Label() string {
+	return d.label
+}
+
+// countedDataChannel counts an application channel in MetricDataChannelsActive until it's closed, fails to read
+// or the connection is closed. Detached channels don't report remote closes.
+type countedDataChannel struct {
+	datachannel.ReadWriteCloser
+
+	metrics  MetricsSink
+	closedCh chan struct{}
+	doneOnce sync.Once
+}
+
+func (c *connection) countDataChannel(dataChannel datachannel.ReadWriteCloser) *countedDataChannel {
+	metrics := c.configuration.metrics
+	incrementMetric(metrics, MetricDataChannelsActive)
+	counted := &countedDataChannel{
+		ReadWriteCloser: dataChannel,
+		metrics:         metrics,
+		closedCh:        make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-c.closedCh:
+			counted.done()
+		case <-counted.closedCh:
+		}
+	}()
+	return counted
+}
+
+func (d *countedDataChannel) Read(p []byte) (int, error) {
+	n, err := d.ReadWriteCloser.Read(p)
+	d.doneOnError(err)
+	return n, err
+}
+
+func (d *countedDataChannel) ReadDataChannel(p []byte) (int, bool, error) {
+	n, isString, err := d.ReadWriteCloser.ReadDataChannel(p)
+	d.doneOnError(err)
+	return n, isString, err
+}
+
+func (d *countedDataChannel) Close() error {
+	d.done()
+	return d.ReadWriteCloser.Close()
+}
+
+// doneOnError stops counting the channel unless err is nil or io.ErrShortBuffer, after which it's still usable.
+func (d *countedDataChannel) doneOnError(err error) {
+	if err != nil && err != io.ErrShortBuffer {
+		d.done()
+	}
+}
+
+func (d *countedDataChannel) done() {
+	d.doneOnce.Do(func() {
+		close(d.closedCh)
+		decrementMetric(d.metrics, MetricDataChannelsActive)
+	})
+}
+
+// HandleDataChannel registers the handler of data channels with the given label, which skip the muxer.
+// A nil handler removes the registration. Channels without a handler are accepted as datagram channels.
+func (t *Transport) HandleDataChannel(label string, handler DataChannelHandler) error {
+	if label == t.dataChannel.label() {
+		return errReservedLabel
+	}
+
+	t.mDataChannelHandlers.Lock()
+	defer t.mDataChannelHandlers.Unlock()
+
+	if handler == nil {
+		delete(t.dataChannelHandlers, label)
+		return nil
+	}
+	t.dataChannelHandlers[label] = handler
+	return nil
+}
+
+func (t *Transport) dataChannelHandler(label string) DataChannelHandler {
+	t.mDataChannelHandlers.RLock()
+	defer t.mDataChannelHandlers.RUnlock()
+	return t.dataChannelHandlers[label]
+}
+
+// OpenDataChannel opens a data channel, which is passed to the remote handler registered for the label. A nil
+// init opens a reliable, ordered channel. Nothing is muxed over it, so it may be unordered or use MaxRetransmits
+// or MaxPacketLifeTime, only negotiated channels are rejected.
+func (c *connection) OpenDataChannel(label string, init *webrtc.DataChannelInit) (DataChannel, error) {
+	logger.Debugf("%s: Open data channel (label: %s)", c.id, label)
+
+	dataChannel, err := c.openDataChannel("open data channel", label, init)
+	if err != nil {
+		return nil, err
+	}
+	return &rawDataChannel{ReadWriteCloser: dataChannel, label: label}, nil
+}
+
+func (c *connection) openDataChannel(op, label string,
+	init *webrtc.DataChannelInit) (datachannel.ReadWriteCloser, error) {
+	if label == c.configuration.dataChannelLabel {
+		return nil, wrapOpError(op, c.configuration.remotePeerID, "", errReservedLabel)
+	}
+	if init != nil {
+		err := validateDataChannelInit(init)
+		if err != nil {
+			return nil, wrapOpError(op, c.configuration.remotePeerID, "", err)
+		}
+	}
+
+	peerConnection, err := c.getPeerConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	dataChannel, err := peerConnection.CreateDataChannel(label, init)
+	if err != nil {
+		return nil, wrapOpError(op, c.configuration.remotePeerID, "", err)
+	}
+
+	var detached detachResult
+	select {
+	case detached = <-detachDataChannel(dataChannel):
+	case <-c.closedCh:
+		return nil, c.closedError(c.closeReason)
+	}
+	if detached.err != nil {
+		return nil, wrapOpError(op, c.configuration.remotePeerID, "", detached.err)
+	}
+
+	if !dataChannel.Ordered() {
+		err = c.awaitChannelOpenDelivered(detached.dataChannel)
+		if err != nil {
+			detached.dataChannel.Close()
+			return nil, err
+		}
+	}
+	return c.countDataChannel(detached.dataChannel), nil
+}
+
+// handleIncomingDataChannel passes a data channel opened by the remote peer to the registered handler, or to
+// the datagram channels waiting to be accepted.
+func (c *connection) handleIncomingDataChannel(dataChannel *webrtc.DataChannel) {
+	var handler DataChannelHandler
+	if c.configuration.dataChannelHandlerFunc != nil {
+		handler = c.configuration.dataChannelHandlerFunc(dataChannel.Label())
+	}
+
+	detachedCh := detachDataChannel(dataChannel)
+	go func() {
+		var detached detachResult
+		select {
+		case detached = <-detachedCh:
+		case <-c.closedCh:
+			return
+		}
+		if detached.err != nil {
+			logger.Warningf("%s: Can't detach data channel (label: %s): %v", c.id, dataChannel.Label(),
+				detached.err)
+			return
+		}
+
+		counted := c.countDataChannel(detached.dataChannel)
+		if handler != nil {
+			handler(c, &rawDataChannel{ReadWriteCloser: counted, label: dataChannel.Label()})
+			return
+		}
+		c.acceptDatagramChannel(dataChannel.Label(), counted)
+	}()
+}
@@ -0,0 +1,140 @@
+package star
+
+import (
+	"errors"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestHandleDataChannel(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	type handled struct {
+		conn  Conn
+		label string
+		data  string
+	}
+	handledCh := make(chan handled, 1)
+	err := listening.HandleDataChannel("chat", func(conn Conn, dataChannel DataChannel) {
+		defer dataChannel.Close()
+
+		buf := make([]byte, 1024)
+		n, err := dataChannel.Read(buf)
+		if err != nil {
+			return
+		}
+		_, err = dataChannel.Write([]byte("pong"))
+		if err != nil {
+			return
+		}
+		handledCh <- handled{conn: conn, label: dataChannel.Label(), data: string(buf[:n])}
+	})
+	require.NoError(t, err)
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// when
+	chat, err := dialed.(Conn).OpenDataChannel("chat", nil)
+	require.NoError(t, err)
+	defer chat.Close()
+	_, err = chat.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	// then
+	select {
+	case h := <-handledCh:
+		assert.Equal(t, "chat", h.label)
+		assert.Equal(t, "ping", h.data)
+		assert.Equal(t, dialing.peerID, h.conn.RemotePeer())
+	case <-time.After(10 * time.Second):
+		t.Fatal("data channel not handled")
+	}
+
+	buf := make([]byte, 1024)
+	n, err := chat.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:n]))
+}
+
+func TestHandleDataChannelRejectsMuxerLabel(t *testing.T) {
+	tpt := New("", nil, nil)
+
+	// when
+	err := tpt.HandleDataChannel(defaultDataChannelLabel, func(Conn, DataChannel) {})
+
+	// then
+	assert.True(t, errors.Is(err, errReservedLabel), "unexpected error: %v", err)
+}
+
+func TestOpenDataChannelWithPartialReliability(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	receivedCh := make(chan string, 1)
+	err := listening.HandleDataChannel("telemetry", func(conn Conn, dataChannel DataChannel) {
+		defer dataChannel.Close()
+
+		buf := make([]byte, 1024)
+		n, err := dataChannel.Read(buf)
+		if err == nil {
+			receivedCh <- string(buf[:n])
+		}
+	})
+	require.NoError(t, err)
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	unordered := false
+	maxPacketLifeTime := uint16(500)
+
+	// when
+	telemetry, err := dialed.(Conn).OpenDataChannel("telemetry", &webrtc.DataChannelInit{
+		Ordered:           &unordered,
+		MaxPacketLifeTime: &maxPacketLifeTime,
+	})
+	require.NoError(t, err)
+	defer telemetry.Close()
+	_, err = telemetry.Write([]byte("cpu:12"))
+	require.NoError(t, err)
+
+	// then
+	select {
+	case received := <-receivedCh:
+		assert.Equal(t, "cpu:12", received)
+	case <-time.After(10 * time.Second):
+		t.Fatal("data channel not handled")
+	}
+}
+
+func TestOpenDataChannelRejectsNegotiatedChannel(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	negotiated := true
+	id := uint16(10)
+
+	// when
+	_, err := dialed.(Conn).OpenDataChannel("chat", &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id})
+
+	// then
+	assert.True(t, errors.Is(err, errNegotiatedDataChannel), "unexpected error: %v", err)
+}
@@ -0,0 +1,240 @@
+package star
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/transport"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNegotiateMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    int
+		sdp      string
+		expected int
+	}{
+		{name: "remote limit", local: 65535, sdp: "m=application 9 DTLS/SCTP 5000\r\na=max-message-size:16384\r\n", expected: 16384},
+		{name: "local limit", local: 1024, sdp: "a=max-message-size:16384\r\n", expected: 1024},
+		{name: "remote without limit", local: 65535, sdp: "a=max-message-size:0\r\n", expected: 65535},
+		{name: "remote default", local: 65535, sdp: "m=application 9 DTLS/SCTP 5000\r\n", expected: 65535},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			size := negotiateMaxMessageSize(test.local, &webrtc.SessionDescription{SDP: test.sdp})
+
+			// then
+			assert.Equal(t, test.expected, size)
+		})
+	}
+}
+
+func TestSetMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		sdp      string
+		expected string
+	}{
+		{
+			name:     "application section last",
+			sdp:      "v=0\r\nm=application 9 DTLS/SCTP 5000\r\na=sctpmap:5000 webrtc-datachannel 1024\r\n",
+			expected: "v=0\r\nm=application 9 DTLS/SCTP 5000\r\na=sctpmap:5000 webrtc-datachannel 1024\r\na=max-message-size:4096\r\n",
+		},
+		{
+			name:     "application section followed by media",
+			sdp:      "v=0\r\nm=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\na=sctp-port:5000\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\n",
+			expected: "v=0\r\nm=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\na=sctp-port:5000\r\na=max-message-size:4096\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\n",
+		},
+		{
+			name:     "replaced attribute",
+			sdp:      "v=0\r\nm=application 9 DTLS/SCTP 5000\r\na=max-message-size:262144\r\na=sctpmap:5000 webrtc-datachannel 1024",
+			expected: "v=0\r\nm=application 9 DTLS/SCTP 5000\r\na=sctpmap:5000 webrtc-datachannel 1024\r\na=max-message-size:4096\r\n",
+		},
+		{
+			name:     "no application section",
+			sdp:      "v=0\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\n",
+			expected: "v=0\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			sdp := setMaxMessageSize(test.sdp, 4096)
+
+			// then
+			assert.Equal(t, test.expected, sdp)
+			if test.expected != test.sdp {
+				assert.Equal(t, 4096, readMaxMessageSize(sdp))
+			}
+		})
+	}
+}
+
+func TestValidateDataChannelInit(t *testing.T) {
+	unordered := false
+	negotiated := true
+	maxRetransmits := uint16(0)
+	maxPacketLifeTime := uint16(100)
+	id := uint16(10)
+
+	tests := []struct {
+		name            string
+		init            webrtc.DataChannelInit
+		expected        error
+		expectedSession error
+	}{
+		{name: "default", init: webrtc.DataChannelInit{}},
+		{name: "unordered", init: webrtc.DataChannelInit{Ordered: &unordered},
+			expectedSession: errUnreliableDataChannel},
+		{name: "max retransmits", init: webrtc.DataChannelInit{MaxRetransmits: &maxRetransmits},
+			expectedSession: errUnreliableDataChannel},
+		{name: "max packet lifetime", init: webrtc.DataChannelInit{MaxPacketLifeTime: &maxPacketLifeTime},
+			expectedSession: errUnreliableDataChannel},
+		{name: "negotiated", init: webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id},
+			expected: errNegotiatedDataChannel, expectedSession: errNegotiatedDataChannel},
+		{name: "ID", init: webrtc.DataChannelInit{ID: &id},
+			expected: errNegotiatedDataChannel, expectedSession: errNegotiatedDataChannel},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			err := validateDataChannelInit(&test.init)
+			sessionErr := validateSessionDataChannelInit(&test.init)
+
+			// then
+			assert.Equal(t, test.expected, err)
+			assert.Equal(t, test.expectedSession, sessionErr)
+		})
+	}
+}
+
+func TestTransportUsesDataChannelConfiguration(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	configuration := DataChannelConfiguration{
+		Label:             "muxed",
+		MaxMessageSize:    4096,
+		ReceiveBufferSize: 4096,
+		MaxBufferedAmount: 8192,
+	}
+	listening := newTestTransport(t, server).WithDataChannelConfiguration(configuration)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithDataChannelConfiguration(configuration)
+	defer dialing.Close()
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+
+	acceptedStreamCh := make(chan io.ReadCloser, 1)
+	go func() {
+		stream, err := accepted.AcceptStream()
+		if err == nil {
+			acceptedStreamCh <- stream
+		}
+		close(acceptedStreamCh)
+	}()
+
+	dialedStream, err := dialed.OpenStream()
+	require.NoError(t, err)
+	data := make([]byte, 100*1024)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	// when
+	_, err = dialedStream.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, dialedStream.Close())
+
+	// then
+	acceptedStream, ok := <-acceptedStreamCh
+	require.True(t, ok, "stream not accepted")
+	received, err := ioutil.ReadAll(acceptedStream)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+}
+
+func TestTransportRejectsNegotiatedDataChannel(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	negotiated := true
+	ctx := WithDialOptions(context.Background(), DialDataChannel(webrtc.DataChannelInit{Negotiated: &negotiated}))
+
+	// when
+	_, err := dialing.Dial(ctx, server.signalMultiaddr(), dialing.peerID)
+
+	// then
+	assert.True(t, errors.Is(err, errNegotiatedDataChannel), "unexpected error: %v", err)
+}
+
+func TestTransportRespectsRemoteReceiveBufferSize(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server).WithDataChannelConfiguration(DataChannelConfiguration{
+		ReceiveBufferSize: 1024,
+	})
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+	assertStreamTransfersData(t, accepted, dialed, 100*1024)
+}
+
+func TestTransportRejectsUnreliableDataChannel(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	unordered := false
+	ctx := WithDialOptions(context.Background(), DialDataChannel(webrtc.DataChannelInit{Ordered: &unordered}))
+
+	// when
+	_, err := dialing.Dial(ctx, server.signalMultiaddr(), dialing.peerID)
+
+	// then
+	assert.True(t, errors.Is(err, errUnreliableDataChannel), "unexpected error: %v", err)
+}
+
+// assertStreamTransfersData writes size random bytes on a stream opened by the dialing side.
+func assertStreamTransfersData(t *testing.T, accepted, dialed transport.CapableConn, size int) {
+	acceptedStreamCh := make(chan io.ReadCloser, 1)
+	go func() {
+		stream, err := accepted.AcceptStream()
+		if err == nil {
+			acceptedStreamCh <- stream
+		}
+		close(acceptedStreamCh)
+	}()
+
+	dialedStream, err := dialed.OpenStream()
+	require.NoError(t, err)
+	data := make([]byte, size)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	// when
+	_, err = dialedStream.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, dialedStream.Close())
+
+	// then
+	acceptedStream, ok := <-acceptedStreamCh
+	require.True(t, ok, "stream not accepted")
+	received, err := ioutil.ReadAll(acceptedStream)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+}
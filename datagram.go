@@ -0,0 +1,136 @@
+package star
+
+import (
+	"errors"
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v2"
+)
+
+const datagramAcceptBacklog = 16
+
+var errReservedLabel = errors.New("label is reserved for the muxed connection")
+
+// DatagramConn is a data channel for datagrams, next to the muxed streams on the same peer connection. By default
+// it's unordered and without retransmissions: messages may be lost or reordered, but they're never split or merged.
+type DatagramConn interface {
+	Label() string
+
+	// MaxMessageSize is the largest message accepted by Send.
+	MaxMessageSize() int
+
+	// Send sends p as a single message or fails with ErrMessageTooLarge.
+	Send(p []byte) error
+
+	// Receive reads a single message. If the message doesn't fit into p, it's dropped and io.ErrShortBuffer
+	// is returned.
+	Receive(p []byte) (int, error)
+
+	Close() error
+}
+
+type datagramConn struct {
+	label          string
+	dataChannel    datachannel.ReadWriteCloser
+	maxMessageSize int
+}
+
+var _ DatagramConn = new(datagramConn)
+
+// OpenDatagramChannel opens a datagram channel, which the remote peer receives with AcceptDatagramChannel. A nil
+// init opens an unordered channel without retransmissions. Nothing is muxed over it, so any ordering and
+// MaxRetransmits or MaxPacketLifeTime are allowed, only negotiated channels are rejected.
+func (c *connection) OpenDatagramChannel(label string, init *webrtc.DataChannelInit) (DatagramConn, error) {
+	logger.Debugf("%s: Open datagram channel (label: %s)", c.id, label)
+
+	if init == nil {
+		ordered := false
+		maxRetransmits := uint16(0)
+		init = &webrtc.DataChannelInit{
+			Ordered:        &ordered,
+			MaxRetransmits: &maxRetransmits,
+		}
+	}
+	dataChannel, err := c.openDataChannel("open datagram channel", label, init)
+	if err != nil {
+		return nil, err
+	}
+	return c.newDatagramConn(label, dataChannel), nil
+}
+
+// awaitChannelOpenDelivered waits until the remote peer acknowledged the DATA_CHANNEL_OPEN message. pion reads
+// unordered messages first, so a message sent earlier could overtake it, which makes the remote peer stop
+// accepting data channels at all.
+func (c *connection) awaitChannelOpenDelivered(dataChannel datachannel.ReadWriteCloser) error {
+	buffered, ok := dataChannel.(bufferedDataChannel)
+	if !ok {
+		return nil
+	}
+
+	deliveredCh := make(chan struct{}, 1)
+	buffered.SetBufferedAmountLowThreshold(1)
+	buffered.OnBufferedAmountLow(func() {
+		notify(deliveredCh)
+	})
+	defer buffered.OnBufferedAmountLow(nil)
+
+	for buffered.BufferedAmount() > 0 {
+		select {
+		case <-deliveredCh:
+		case <-c.closedCh:
+			return c.closedError(c.closeReason)
+		}
+	}
+	return nil
+}
+
+// AcceptDatagramChannel waits for a data channel opened by the remote peer, which neither carries the muxed
+// connection nor has a handler registered with HandleDataChannel.
+func (c *connection) AcceptDatagramChannel() (DatagramConn, error) {
+	select {
+	case datagram := <-c.datagramCh:
+		return datagram, nil
+	case <-c.closedCh:
+		return nil, c.closedError(c.closeReason)
+	}
+}
+
+func (c *connection) acceptDatagramChannel(label string, dataChannel datachannel.ReadWriteCloser) {
+	select {
+	case c.datagramCh <- c.newDatagramConn(label, dataChannel):
+	default:
+		logger.Warningf("%s: Too many datagram channels waiting to be accepted, close %s", c.id, label)
+		dataChannel.Close()
+	}
+}
+
+func (c *connection) newDatagramConn(label string, dataChannel datachannel.ReadWriteCloser) *datagramConn {
+	return &datagramConn{
+		label:          label,
+		dataChannel:    dataChannel,
+		maxMessageSize: c.configuration.stream.maxMessageSize,
+	}
+}
+
+func (d *datagramConn) Label() string {
+	return d.label
+}
+
+func (d *datagramConn) MaxMessageSize() int {
+	return d.maxMessageSize
+}
+
+func (d *datagramConn) Send(p []byte) error {
+	if d.maxMessageSize > 0 && len(p) > d.maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	_, err := d.dataChannel.Write(p)
+	return err
+}
+
+func (d *datagramConn) Receive(p []byte) (int, error) {
+	return d.dataChannel.Read(p)
+}
+
+func (d *datagramConn) Close() error {
+	return d.dataChannel.Close()
+}
@@ -0,0 +1,70 @@
+package star
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDatagramChannel(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+
+	acceptedCh := make(chan DatagramConn, 1)
+	go func() {
+		datagram, err := accepted.(Conn).AcceptDatagramChannel()
+		if err == nil {
+			acceptedCh <- datagram
+		}
+		close(acceptedCh)
+	}()
+
+	// when
+	opened, err := dialed.(Conn).OpenDatagramChannel("game-state", nil)
+	require.NoError(t, err)
+	defer opened.Close()
+	require.NoError(t, opened.Send([]byte("position:1,2")))
+
+	// then
+	datagram, ok := <-acceptedCh
+	require.True(t, ok, "datagram channel not accepted")
+	defer datagram.Close()
+	assert.Equal(t, "game-state", datagram.Label())
+
+	buf := make([]byte, datagram.MaxMessageSize())
+	n, err := datagram.Receive(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "position:1,2", string(buf[:n]))
+
+	err = opened.Send(make([]byte, opened.MaxMessageSize()+1))
+	assert.True(t, errors.Is(err, ErrMessageTooLarge), "unexpected error: %v", err)
+
+	_, err = dialed.OpenStream() // muxed streams keep working next to datagram channels
+	assert.NoError(t, err)
+}
+
+func TestDatagramChannelRejectsMuxerLabel(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// when
+	_, err := dialed.(Conn).OpenDatagramChannel(defaultDataChannelLabel, nil)
+
+	// then
+	assert.True(t, errors.Is(err, errReservedLabel), "unexpected error: %v", err)
+}
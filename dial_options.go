@@ -0,0 +1,95 @@
+package star
+
+import (
+	"context"
+	"github.com/pion/webrtc/v2"
+	"time"
+)
+
+// DialOption overrides a transport default for a single dial. Options are attached to the dial context with
+// WithDialOptions, so they pass through the libp2p host and swarm untouched.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	handshakeTimeout   time.Duration
+	iceTransportPolicy *webrtc.ICETransportPolicy
+	iceServers         []webrtc.ICEServer
+	dataChannelInit    *webrtc.DataChannelInit
+	tracks             []*webrtc.Track
+}
+
+type dialOptionsKey struct{}
+
+// WithDialOptions returns a context carrying the options in addition to the ones already attached to ctx.
+// Later options take precedence.
+func WithDialOptions(ctx context.Context, options ...DialOption) context.Context {
+	previous, _ := ctx.Value(dialOptionsKey{}).([]DialOption)
+	combined := make([]DialOption, 0, len(previous)+len(options))
+	combined = append(combined, previous...)
+	combined = append(combined, options...)
+	return context.WithValue(ctx, dialOptionsKey{}, combined)
+}
+
+// DialHandshakeTimeout limits the time from sending the offer until the data channel is open. The dial fails
+// with ErrHandshakeTimeout when it expires.
+func DialHandshakeTimeout(timeout time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.handshakeTimeout = timeout
+	}
+}
+
+// DialICETransportPolicy selects the ICE candidates to use, e.g. webrtc.ICETransportPolicyRelay for
+// relay-only connections.
+func DialICETransportPolicy(policy webrtc.ICETransportPolicy) DialOption {
+	return func(o *dialOptions) {
+		o.iceTransportPolicy = &policy
+	}
+}
+
+// DialICEServers replaces the STUN and TURN servers of the WebRTC configuration.
+func DialICEServers(servers []webrtc.ICEServer) DialOption {
+	return func(o *dialOptions) {
+		o.iceServers = servers
+	}
+}
+
+// DialDataChannel sets the parameters of the data channel carrying the muxed connection. The stream muxer
+// needs reliable, in-order delivery, so unreliable and negotiated channels are rejected.
+func DialDataChannel(init webrtc.DataChannelInit) DialOption {
+	return func(o *dialOptions) {
+		o.dataChannelInit = &init
+	}
+}
+
+// DialTracks sends the local media tracks to the remote peer, which receives them with a handler registered with
+// HandleTrack. Conn.AddTrack adds tracks to an established connection.
+func DialTracks(tracks ...*webrtc.Track) DialOption {
+	return func(o *dialOptions) {
+		o.tracks = append(o.tracks, tracks...)
+	}
+}
+
+func dialOptionsFromContext(ctx context.Context) dialOptions {
+	var options dialOptions
+	if dialOptions, ok := ctx.Value(dialOptionsKey{}).([]DialOption); ok {
+		for _, option := range dialOptions {
+			option(&options)
+		}
+	}
+	return options
+}
+
+func (o dialOptions) overridesWebRTCConfiguration() bool {
+	return o.iceTransportPolicy != nil || o.iceServers != nil
+}
+
+// webRTCConfiguration applies the overrides to the transport configuration.
+func (o dialOptions) webRTCConfiguration(configuration webrtc.Configuration) webrtc.Configuration {
+	if o.iceTransportPolicy != nil {
+		configuration.ICETransportPolicy = *o.iceTransportPolicy
+	}
+	if o.iceServers != nil {
+		configuration.ICEServers = o.iceServers
+	}
+	return configuration
+}
@@ -0,0 +1,56 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDialOptionsOverrideWebRTCConfiguration(t *testing.T) {
+	defaults := webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:stun.example.com"}}},
+	}
+	relays := []webrtc.ICEServer{{URLs: []string{"turn:turn.example.com"}, Username: "user", Credential: "pass"}}
+
+	ctx := WithDialOptions(context.Background(), DialHandshakeTimeout(time.Minute), DialICEServers(relays))
+	ctx = WithDialOptions(ctx, DialHandshakeTimeout(time.Second),
+		DialICETransportPolicy(webrtc.ICETransportPolicyRelay))
+
+	// when
+	options := dialOptionsFromContext(ctx)
+	configuration := options.webRTCConfiguration(defaults)
+
+	// then
+	assert.Equal(t, time.Second, options.handshakeTimeout)
+	assert.Equal(t, webrtc.ICETransportPolicyRelay, configuration.ICETransportPolicy)
+	assert.Equal(t, relays, configuration.ICEServers)
+	assert.Equal(t, webrtc.ICETransportPolicyAll, defaults.ICETransportPolicy)
+}
+
+func TestDialHandshakeTimeout(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	_, err := listening.Listen(server.signalMultiaddr()) // never accepts, so offers are not answered
+	require.NoError(t, err)
+	require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+
+	ctx := WithDialOptions(context.Background(), DialHandshakeTimeout(200*time.Millisecond))
+
+	// when
+	startTime := time.Now()
+	_, err = dialing.Dial(ctx, server.signalMultiaddr(), listening.peerID)
+
+	// then
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout), "unexpected error: %v", err)
+	assert.True(t, time.Since(startTime) < 5*time.Second)
+}
@@ -0,0 +1,96 @@
+package star
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"strings"
+)
+
+var (
+	ErrSignalUnavailable = errors.New("signal server unavailable")
+	ErrPeerNotPresent    = errors.New("peer not present on signal server")
+	ErrHandshakeTimeout  = errors.New("handshake answer timeout")
+	ErrICEFailed         = errors.New("ICE connection failed")
+	ErrConnectionClosed  = errors.New("connection closed")
+	ErrTransportClosed   = errors.New("transport closed")
+	ErrMessageTooLarge   = errors.New("message exceeds the maximum message size")
+	ErrConnectionIdle    = errors.New("connection idle")
+	ErrPeerUnresponsive  = errors.New("peer unresponsive")
+
+	ErrRenegotiationFailed = errors.New("renegotiation failed")
+	ErrHandshakeRejected   = errors.New("handshake rejected by remote peer")
+)
+
+// OpError is returned by dial, accept and stream operations. Kind is one of the exported sentinel errors
+// (matched with errors.Is), Err is the underlying cause if there is any.
+type OpError struct {
+	Op       string
+	Peer     peer.ID
+	IntentID string
+	Kind     error
+	Err      error
+}
+
+func (e *OpError) Error() string {
+	var buf strings.Builder
+	buf.WriteString(e.Op)
+	if e.Peer != "" {
+		buf.WriteString(fmt.Sprintf(" (peer: %s)", e.Peer))
+	}
+	if e.IntentID != "" {
+		buf.WriteString(fmt.Sprintf(" (intentID: %s)", e.IntentID))
+	}
+
+	switch {
+	case e.Kind != nil && e.Err != nil:
+		buf.WriteString(fmt.Sprintf(": %v: %v", e.Kind, e.Err))
+	case e.Kind != nil:
+		buf.WriteString(fmt.Sprintf(": %v", e.Kind))
+	case e.Err != nil:
+		buf.WriteString(fmt.Sprintf(": %v", e.Err))
+	}
+	return buf.String()
+}
+
+func (e *OpError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+func newOpError(op string, p peer.ID, kind error, err error) *OpError {
+	return &OpError{
+		Op:   op,
+		Peer: p,
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+func wrapOpError(op string, p peer.ID, intentID string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		if opErr.Peer == "" {
+			opErr.Peer = p
+		}
+		if opErr.IntentID == "" {
+			opErr.IntentID = intentID
+		}
+		return err
+	}
+
+	switch err {
+	case ErrSignalUnavailable, ErrPeerNotPresent, ErrHandshakeTimeout, ErrICEFailed, ErrConnectionClosed,
+		ErrTransportClosed, ErrMessageTooLarge, ErrConnectionIdle, ErrPeerUnresponsive,
+		ErrRenegotiationFailed, ErrHandshakeRejected:
+		return &OpError{Op: op, Peer: p, IntentID: intentID, Kind: err}
+	}
+	return &OpError{Op: op, Peer: p, IntentID: intentID, Err: err}
+}
@@ -0,0 +1,47 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWrapOpErrorWithSentinel(t *testing.T) {
+	// when
+	err := wrapOpError("dial", peer.ID("remote"), "signal-1", ErrHandshakeTimeout)
+
+	// then
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout))
+	assert.False(t, errors.Is(err, ErrSignalUnavailable))
+
+	var opErr *OpError
+	require.True(t, errors.As(err, &opErr))
+	assert.Equal(t, "dial", opErr.Op)
+	assert.Equal(t, "signal-1", opErr.IntentID)
+}
+
+func TestWrapOpErrorWithCause(t *testing.T) {
+	// when
+	err := wrapOpError("dial", peer.ID("remote"), "signal-1", context.Canceled)
+
+	// then
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.Is(err, ErrHandshakeTimeout))
+}
+
+func TestWrapOpErrorKeepsKindAndCause(t *testing.T) {
+	cause := errors.New("peer is not available")
+	original := &OpError{Op: "dial", Kind: ErrPeerNotPresent, Err: cause}
+
+	// when
+	err := wrapOpError("dial", peer.ID("remote"), "signal-1", original)
+
+	// then
+	assert.True(t, errors.Is(err, ErrPeerNotPresent))
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, peer.ID("remote"), original.Peer)
+	assert.Contains(t, err.Error(), "peer not present on signal server: peer is not available")
+}
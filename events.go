@@ -0,0 +1,125 @@
+package star
+
+import (
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pion/webrtc/v2"
+	"reflect"
+	"sync"
+)
+
+type EvtSignalConnected struct {
+	SignalMultiaddr ma.Multiaddr
+}
+
+type EvtSignalDisconnected struct {
+	SignalMultiaddr ma.Multiaddr
+	Err             error
+}
+
+type EvtPeerAnnounced struct {
+	Peer            peer.ID
+	SignalMultiaddr ma.Multiaddr
+}
+
+type EvtHandshakeStarted struct {
+	Peer     peer.ID
+	IntentID string
+	Outbound bool
+}
+
+type EvtHandshakeSucceeded struct {
+	Peer     peer.ID
+	IntentID string
+	Outbound bool
+}
+
+type EvtHandshakeFailed struct {
+	Peer     peer.ID
+	IntentID string
+	Outbound bool
+	Err      error
+}
+
+type EvtConnectionStateChanged struct {
+	Peer            peer.ID
+	RemoteMultiaddr ma.Multiaddr
+	State           webrtc.ICEConnectionState
+}
+
+var eventTypes = []interface{}{
+	new(EvtSignalConnected),
+	new(EvtSignalDisconnected),
+	new(EvtPeerAnnounced),
+	new(EvtHandshakeStarted),
+	new(EvtHandshakeSucceeded),
+	new(EvtHandshakeFailed),
+	new(EvtConnectionStateChanged),
+}
+
+type eventEmitters struct {
+	emitters map[reflect.Type]event.Emitter
+
+	m      sync.RWMutex
+	closed bool
+}
+
+func newEventEmitters(bus event.Bus) (*eventEmitters, error) {
+	e := &eventEmitters{
+		emitters: map[reflect.Type]event.Emitter{},
+	}
+
+	for _, eventType := range eventTypes {
+		emitter, err := bus.Emitter(eventType)
+		if err != nil {
+			e.close()
+			return nil, err
+		}
+		e.emitters[reflect.TypeOf(eventType).Elem()] = emitter
+	}
+	return e, nil
+}
+
+func (e *eventEmitters) emit(evt interface{}) {
+	if e == nil {
+		return
+	}
+
+	e.m.RLock()
+	defer e.m.RUnlock()
+	if e.closed {
+		return // connections may report state changes after the transport is closed
+	}
+
+	emitter, ok := e.emitters[reflect.TypeOf(evt)]
+	if !ok {
+		logger.Errorf("No emitter registered for event type %T", evt)
+		return
+	}
+
+	err := emitter.Emit(evt)
+	if err != nil {
+		logger.Warningf("Can't emit event %T: %v", evt, err)
+	}
+}
+
+func (e *eventEmitters) close() {
+	if e == nil {
+		return
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+
+	for _, emitter := range e.emitters {
+		err := emitter.Close()
+		if err != nil {
+			logger.Warningf("Can't close event emitter: %v", err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const eventTimeout = 5 * time.Second
+
+func TestEventEmittersPublishOnBus(t *testing.T) {
+	bus := eventbus.NewBus()
+	subscription, err := bus.Subscribe([]interface{}{new(EvtHandshakeStarted), new(EvtHandshakeFailed)})
+	require.NoError(t, err)
+	defer subscription.Close()
+
+	events, err := newEventEmitters(bus)
+	require.NoError(t, err)
+	defer events.close()
+
+	remotePeerID := peer.ID("remote")
+	handshakeErr := errors.New("handshake answer timeout")
+
+	// when
+	go func() {
+		events.emit(EvtHandshakeStarted{Peer: remotePeerID, IntentID: "signal-1", Outbound: true})
+		events.emit(EvtHandshakeFailed{Peer: remotePeerID, IntentID: "signal-1", Outbound: true, Err: handshakeErr})
+	}()
+
+	// then
+	assert.Equal(t, EvtHandshakeStarted{Peer: remotePeerID, IntentID: "signal-1", Outbound: true},
+		awaitEvent(t, subscription.Out()))
+	assert.Equal(t, EvtHandshakeFailed{Peer: remotePeerID, IntentID: "signal-1", Outbound: true, Err: handshakeErr},
+		awaitEvent(t, subscription.Out()))
+}
+
+func TestEventEmittersWithoutBus(t *testing.T) {
+	var events *eventEmitters
+
+	// when
+	events.emit(EvtSignalConnected{})
+	events.close()
+}
+
+func awaitEvent(t *testing.T, out <-chan interface{}) interface{} {
+	select {
+	case evt := <-out:
+		return evt
+	case <-time.After(eventTimeout):
+		t.Fatal("timeout occurred while waiting for the event")
+		return nil
+	}
+}
+
+func TestTransportPublishesEventsOnBus(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	bus := eventbus.NewBus()
+	subscription, err := bus.Subscribe([]interface{}{new(EvtSignalConnected), new(EvtHandshakeSucceeded),
+		new(EvtConnectionStateChanged)}, eventbus.BufSize(64))
+	require.NoError(t, err)
+	defer subscription.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithEventBus(bus)
+	defer dialing.Close()
+
+	// when
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	require.NoError(t, dialed.Close())
+
+	// then
+	signalConnected := awaitEventOfType(t, subscription.Out(), EvtSignalConnected{}).(EvtSignalConnected)
+	assert.Equal(t, server.signalMultiaddr(), signalConnected.SignalMultiaddr)
+
+	handshakeSucceeded := awaitEventOfType(t, subscription.Out(), EvtHandshakeSucceeded{}).(EvtHandshakeSucceeded)
+	assert.Equal(t, listening.peerID, handshakeSucceeded.Peer)
+	assert.True(t, handshakeSucceeded.Outbound)
+
+	stateChanged := awaitEventOfType(t, subscription.Out(), EvtConnectionStateChanged{}).(EvtConnectionStateChanged)
+	assert.Equal(t, EvtConnectionStateChanged{Peer: listening.peerID, RemoteMultiaddr: dialed.RemoteMultiaddr(),
+		State: webrtc.ICEConnectionStateClosed}, stateChanged)
+}
+
+type failingEventBus struct {
+	event.Bus
+}
+
+func (failingEventBus) Emitter(interface{}, ...event.EmitterOpt) (event.Emitter, error) {
+	return nil, errors.New("emitter unavailable")
+}
+
+func TestTransportFailsWithoutEventEmitters(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	tr := newTestTransport(t, server).WithEventBus(failingEventBus{})
+	defer tr.Close()
+
+	// when
+	_, listenErr := tr.Listen(server.signalMultiaddr())
+	_, dialErr := tr.Dial(context.Background(), server.signalMultiaddr(), tr.peerID)
+
+	// then
+	assert.EqualError(t, listenErr, "listen: can't create event emitters: emitter unavailable")
+	assert.EqualError(t, dialErr, fmt.Sprintf("dial (peer: %s): can't create event emitters: emitter unavailable",
+		tr.peerID))
+}
+
+// awaitEventOfType skips events of other types.
+func awaitEventOfType(t *testing.T, out <-chan interface{}, evt interface{}) interface{} {
+	for {
+		received := awaitEvent(t, out)
+		if reflect.TypeOf(received) == reflect.TypeOf(evt) {
+			return received
+		}
+	}
+}
@@ -15,8 +15,8 @@ import (
 )
 
 const (
-	protocolID = "/examples-echo/1.0.0"
-	signalAddr = "/dns4/wrtc-star.discovery.libp2p.io/tcp/443/wss/p2p-webrtc-star"
+	protocolID        = "/examples-echo/1.0.0"
+	defaultSignalAddr = "/dns4/wrtc-star.discovery.libp2p.io/tcp/443/wss/p2p-webrtc-star"
 
 	waitForStreamTimeout = 5 * time.Minute
 )
@@ -39,7 +39,7 @@ func main() {
 	wg.Add(1)
 
 	t := new(testing.T)
-	host := testutils.MustCreateHost(t, ctx, signalAddr)
+	host := testutils.MustCreateHost(t, ctx, signalAddr())
 	log.Printf("Client host ID: %s\n", host.ID())
 
 	hostStream := testutils.WaitForStream(t, func() (network.Stream, error) {
@@ -67,3 +67,11 @@ func main() {
 		i++
 	}
 }
+
+// signalAddr may be overridden with SIGNAL_ADDR, e.g. /dnsaddr/example.com/p2p-webrtc-star.
+func signalAddr() string {
+	if addr := os.Getenv("SIGNAL_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultSignalAddr
+}
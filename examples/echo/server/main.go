@@ -5,14 +5,15 @@ import (
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/mtojek/go-libp2p-webrtc-star/testutils"
 	"log"
+	"os"
 	"sync"
 	"testing"
 	"time"
 )
 
 const (
-	protocolID = "/examples-echo/1.0.0"
-	signalAddr = "/dns4/wrtc-star.discovery.libp2p.io/tcp/443/wss/p2p-webrtc-star"
+	protocolID        = "/examples-echo/1.0.0"
+	defaultSignalAddr = "/dns4/wrtc-star.discovery.libp2p.io/tcp/443/wss/p2p-webrtc-star"
 )
 
 func main() {
@@ -22,7 +23,7 @@ func main() {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	host := testutils.MustCreateHost(new(testing.T), ctx, signalAddr)
+	host := testutils.MustCreateHost(new(testing.T), ctx, signalAddr())
 	log.Printf("Server host ID: %s\n", host.ID())
 
 	host.SetStreamHandler(protocolID, func(stream network.Stream) {
@@ -46,3 +47,11 @@ func main() {
 
 	wg.Wait()
 }
+
+// signalAddr may be overridden with SIGNAL_ADDR, e.g. /dnsaddr/example.com/p2p-webrtc-star.
+func signalAddr() string {
+	if addr := os.Getenv("SIGNAL_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultSignalAddr
+}
@@ -6,6 +6,7 @@ require (
 	github.com/gorilla/websocket v1.4.1
 	github.com/ipfs/go-log v0.0.1
 	github.com/libp2p/go-conn-security v0.1.0 // indirect
+	github.com/libp2p/go-eventbus v0.1.0
 	github.com/libp2p/go-libp2p v0.3.1
 	github.com/libp2p/go-libp2p-blankhost v0.1.4 // indirect
 	github.com/libp2p/go-libp2p-core v0.2.2
@@ -22,13 +23,16 @@ require (
 	github.com/libp2p/go-stream-muxer v0.1.0 // indirect
 	github.com/libp2p/go-testutil v0.1.0 // indirect
 	github.com/multiformats/go-multiaddr v0.0.4
+	github.com/multiformats/go-multiaddr-dns v0.0.2
 	github.com/multiformats/go-multiaddr-fmt v0.0.1
 	github.com/multiformats/go-multiaddr-net v0.0.1
-	github.com/pion/datachannel v1.4.5
-	github.com/pion/webrtc/v2 v2.1.3
-	github.com/stretchr/testify v1.4.0
+	github.com/pion/datachannel v1.4.21
+	github.com/pion/webrtc/v2 v2.2.26
+	github.com/prometheus/client_golang v1.2.1
+	github.com/stretchr/testify v1.6.1
 	github.com/whyrusleeping/go-smux-multiplex v3.0.16+incompatible // indirect
 	github.com/whyrusleeping/go-smux-multistream v2.0.2+incompatible // indirect
 	github.com/whyrusleeping/go-smux-yamux v2.0.9+incompatible // indirect
 	github.com/whyrusleeping/yamux v1.2.0 // indirect
+	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6
 )
@@ -36,13 +36,18 @@ func (l *listener) Close() error {
 }
 
 func (l *listener) Addr() net.Addr {
-	networkAddress, err := manet.ToNetAddr(l.address)
+	networkAddress, err := manet.ToNetAddr(l.Multiaddr())
 	if err != nil {
 		logger.Fatal(err)
 	}
 	return networkAddress
 }
 
+// Multiaddr returns the address of the star server in use. It differs from the listen address for /dnsaddr
+// addresses once they are resolved.
 func (l *listener) Multiaddr() ma.Multiaddr {
+	if endpoint, ok := l.signal.endpoints.current(); ok {
+		return endpoint.signalMultiaddr
+	}
 	return l.address
 }
@@ -0,0 +1,134 @@
+package star
+
+import (
+	"github.com/pion/webrtc/v2"
+	"sync"
+)
+
+// TrackHandler is called in its own goroutine for every media track received from the remote peer.
+type TrackHandler func(conn Conn, track *webrtc.Track, receiver *webrtc.RTPReceiver)
+
+// HandleTrack registers the handler of remote media tracks, a nil handler removes it. Tracks received without
+// a handler are ignored.
+//
+// The dialing peer sends the tracks passed with DialTracks during the handshake. Both peers add tracks to
+// an established connection with Conn.AddTrack.
+func (t *Transport) HandleTrack(handler TrackHandler) {
+	t.mTrackHandler.Lock()
+	defer t.mTrackHandler.Unlock()
+	t.trackHandler = handler
+}
+
+func (t *Transport) getTrackHandler() TrackHandler {
+	t.mTrackHandler.RLock()
+	defer t.mTrackHandler.RUnlock()
+	return t.trackHandler
+}
+
+type remoteTrack struct {
+	track    *webrtc.Track
+	receiver *webrtc.RTPReceiver
+}
+
+// trackHandoff holds the remote tracks, which pion may announce before the connection is established.
+type trackHandoff struct {
+	handlerFunc func() TrackHandler
+
+	m          sync.Mutex
+	connection *connection
+	pending    []remoteTrack
+}
+
+func newTrackHandoff(peerConnection *webrtc.PeerConnection, handlerFunc func() TrackHandler) *trackHandoff {
+	h := &trackHandoff{handlerFunc: handlerFunc}
+	peerConnection.OnTrack(h.onTrack)
+	return h
+}
+
+func (h *trackHandoff) onTrack(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+	h.m.Lock()
+	c := h.connection
+	if c == nil {
+		h.pending = append(h.pending, remoteTrack{track: track, receiver: receiver})
+		h.m.Unlock()
+		return
+	}
+	h.m.Unlock()
+
+	h.handle(c, remoteTrack{track: track, receiver: receiver})
+}
+
+// ready passes the pending and all future tracks to the handler.
+func (h *trackHandoff) ready(c *connection) {
+	h.m.Lock()
+	h.connection = c
+	pending := h.pending
+	h.pending = nil
+	h.m.Unlock()
+
+	for _, t := range pending {
+		h.handle(c, t)
+	}
+}
+
+func (h *trackHandoff) handle(c *connection, t remoteTrack) {
+	handler := h.handlerFunc()
+	if handler == nil {
+		logger.Warningf("%s: No track handler, ignore track (ID: %s, kind: %s)", c.id, t.track.ID(), t.track.Kind())
+		return
+	}
+	go handler(c, t.track, t.receiver)
+}
+
+func (s *signal) registerTrackHandoff(peerConnection *webrtc.PeerConnection) {
+	s.mTrackHandoffs.Lock()
+	defer s.mTrackHandoffs.Unlock()
+	s.trackHandoffs[peerConnection] = newTrackHandoff(peerConnection, s.transport.getTrackHandler)
+}
+
+func (s *signal) unregisterTrackHandoff(peerConnection *webrtc.PeerConnection) *trackHandoff {
+	s.mTrackHandoffs.Lock()
+	defer s.mTrackHandoffs.Unlock()
+
+	handoff := s.trackHandoffs[peerConnection]
+	delete(s.trackHandoffs, peerConnection)
+	return handoff
+}
+
+func addTracks(peerConnection *webrtc.PeerConnection, tracks []*webrtc.Track) error {
+	for _, track := range tracks {
+		_, err := peerConnection.AddTrack(track)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTrack sends a local media track to the remote peer, which receives it with a handler registered with
+// HandleTrack. The connection is renegotiated over the signal server, AddTrack returns once the remote peer
+// answered. Tracks can't be removed.
+func (c *connection) AddTrack(track *webrtc.Track) (*webrtc.RTPSender, error) {
+	peerConnection, err := c.getPeerConnection()
+	if err != nil {
+		return nil, err
+	}
+	if !c.remoteSupports(featureRenegotiation) {
+		return nil, newOpError("add track", c.configuration.remotePeerID, ErrRenegotiationFailed,
+			errRenegotiationUnsupported)
+	}
+
+	// a track of its own gets a new media section, which both peers match to their new transceivers
+	transceiver, err := peerConnection.AddTransceiverFromTrack(track, webrtc.RtpTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	})
+	if err != nil {
+		return nil, wrapOpError("add track", c.configuration.remotePeerID, "", err)
+	}
+
+	err = c.configuration.renegotiateFunc(c, track.Kind())
+	if err != nil {
+		return nil, wrapOpError("add track", c.configuration.remotePeerID, "", err)
+	}
+	return transceiver.Sender(), nil
+}
@@ -0,0 +1,132 @@
+package star
+
+import (
+	"errors"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type receivedTrack struct {
+	conn    Conn
+	trackID string
+	kind    webrtc.RTPCodecType
+	payload uint8
+}
+
+func recordTracks(receivedCh chan<- receivedTrack) TrackHandler {
+	return func(conn Conn, track *webrtc.Track, _ *webrtc.RTPReceiver) {
+		packet, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		receivedCh <- receivedTrack{conn: conn, trackID: track.ID(), kind: track.Kind(), payload: packet.PayloadType}
+	}
+}
+
+func newTestVideoTrack(t *testing.T, id string) *webrtc.Track {
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, rand.Uint32(), id, "star",
+		webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.NoError(t, err)
+	return track
+}
+
+// awaitTrack writes VP8 samples to the track until the remote peer receives one.
+func awaitTrack(t *testing.T, track *webrtc.Track, receivedCh <-chan receivedTrack) receivedTrack {
+	frame := []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a, 0x01, 0x00, 0x01, 0x00}
+	timeout := time.After(10 * time.Second)
+	for {
+		require.NoError(t, track.WriteSample(media.Sample{Data: frame, Samples: 3000}))
+
+		select {
+		case r := <-receivedCh:
+			return r
+		case <-time.After(20 * time.Millisecond):
+		case <-timeout:
+			require.FailNow(t, "track not received")
+		}
+	}
+}
+
+func TestTransportSendsTracks(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	receivedCh := make(chan receivedTrack, 1)
+	listening.HandleTrack(recordTracks(receivedCh))
+	track := newTestVideoTrack(t, "camera")
+
+	// when
+	_, dialed := connectTestTransports(t, server, listening, dialing, DialTracks(track))
+	defer dialed.Close()
+
+	// then
+	r := awaitTrack(t, track, receivedCh)
+	assert.Equal(t, dialing.peerID, r.conn.RemotePeer())
+	assert.Equal(t, "camera", r.trackID)
+	assert.Equal(t, webrtc.RTPCodecTypeVideo, r.kind)
+	assert.Equal(t, uint8(webrtc.DefaultPayloadTypeVP8), r.payload)
+}
+
+func TestTransportRenegotiatesTracks(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	listenerReceivedCh := make(chan receivedTrack, 1)
+	listening.HandleTrack(recordTracks(listenerReceivedCh))
+	dialerReceivedCh := make(chan receivedTrack, 1)
+	dialing.HandleTrack(recordTracks(dialerReceivedCh))
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+	listenerTrack := newTestVideoTrack(t, "listener-camera")
+	dialerTrack := newTestVideoTrack(t, "dialer-camera")
+
+	// when
+	_, err := accepted.(Conn).AddTrack(listenerTrack)
+	require.NoError(t, err)
+	_, err = dialed.(Conn).AddTrack(dialerTrack)
+	require.NoError(t, err)
+
+	// then
+	r := awaitTrack(t, listenerTrack, dialerReceivedCh)
+	assert.Equal(t, listening.peerID, r.conn.RemotePeer())
+	assert.Equal(t, "listener-camera", r.trackID)
+
+	r = awaitTrack(t, dialerTrack, listenerReceivedCh)
+	assert.Equal(t, dialing.peerID, r.conn.RemotePeer())
+	assert.Equal(t, "dialer-camera", r.trackID)
+	assert.False(t, accepted.IsClosed())
+}
+
+func TestConnectionAddTrackRequiresRenegotiationSupport(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	dialed.(*connection).configuration.remoteFeatures = nil // e.g. js-libp2p-webrtc-star
+
+	// when
+	_, err := dialed.(Conn).AddTrack(newTestVideoTrack(t, "camera"))
+
+	// then
+	assert.True(t, errors.Is(err, ErrRenegotiationFailed), "unexpected error: %v", err)
+}
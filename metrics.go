@@ -0,0 +1,140 @@
+package star
+
+import "time"
+
+type MetricKind int
+
+const (
+	CounterMetric MetricKind = iota
+	GaugeMetric
+	HistogramMetric
+)
+
+type Metric struct {
+	Name string
+	Help string
+	Kind MetricKind
+}
+
+var (
+	MetricSignalConnects = Metric{
+		Name: "signal_connects_total",
+		Help: "Number of established connections to signal servers.",
+		Kind: CounterMetric,
+	}
+	MetricSignalReconnects = Metric{
+		Name: "signal_reconnects_total",
+		Help: "Number of connections to signal servers re-established after a failure.",
+		Kind: CounterMetric,
+	}
+	MetricPeerAnnouncements = Metric{
+		Name: "peer_announcements_total",
+		Help: "Number of ws-peer announcements received from signal servers.",
+		Kind: CounterMetric,
+	}
+	MetricOffersSent = Metric{
+		Name: "handshake_offers_sent_total",
+		Help: "Number of handshake offers sent to remote peers.",
+		Kind: CounterMetric,
+	}
+	MetricOffersReceived = Metric{
+		Name: "handshake_offers_received_total",
+		Help: "Number of handshake offers received from remote peers.",
+		Kind: CounterMetric,
+	}
+	MetricHandshakeDuration = Metric{
+		Name: "handshake_duration_seconds",
+		Help: "Time between sending a handshake offer and receiving the answer.",
+		Kind: HistogramMetric,
+	}
+	MetricDialsCoalesced = Metric{
+		Name: "dials_coalesced_total",
+		Help: "Number of dials which got the connection of a concurrent dial to the same peer.",
+		Kind: CounterMetric,
+	}
+	MetricHandshakeTimeouts = Metric{
+		Name: "handshake_timeouts_total",
+		Help: "Number of handshakes which did not receive an answer in time.",
+		Kind: CounterMetric,
+	}
+	MetricConnectionsAccepted = Metric{
+		Name: "connections_accepted_total",
+		Help: "Number of accepted incoming connections.",
+		Kind: CounterMetric,
+	}
+	MetricConnectionsRejected = Metric{
+		Name: "connections_rejected_total",
+		Help: "Number of incoming handshake offers which could not be accepted.",
+		Kind: CounterMetric,
+	}
+	MetricPeerConnectionsActive = Metric{
+		Name: "peer_connections_active",
+		Help: "Number of open WebRTC peer connections.",
+		Kind: GaugeMetric,
+	}
+	MetricPeerConnectionsPooled = Metric{
+		Name: "peer_connections_pooled",
+		Help: "Number of prewarmed WebRTC peer connections waiting in the pool.",
+		Kind: GaugeMetric,
+	}
+	MetricDataChannelsActive = Metric{
+		Name: "data_channels_active",
+		Help: "Number of open data channels, including datagram and application channels.",
+		Kind: GaugeMetric,
+	}
+	MetricStreamBytesSent = Metric{
+		Name: "stream_bytes_sent_total",
+		Help: "Number of bytes written to data channels.",
+		Kind: CounterMetric,
+	}
+	MetricStreamBytesReceived = Metric{
+		Name: "stream_bytes_received_total",
+		Help: "Number of bytes read from data channels.",
+		Kind: CounterMetric,
+	}
+)
+
+var Metrics = []Metric{
+	MetricSignalConnects,
+	MetricSignalReconnects,
+	MetricPeerAnnouncements,
+	MetricOffersSent,
+	MetricOffersReceived,
+	MetricHandshakeDuration,
+	MetricHandshakeTimeouts,
+	MetricDialsCoalesced,
+	MetricConnectionsAccepted,
+	MetricConnectionsRejected,
+	MetricPeerConnectionsActive,
+	MetricPeerConnectionsPooled,
+	MetricDataChannelsActive,
+	MetricStreamBytesSent,
+	MetricStreamBytesReceived,
+}
+
+// MetricsSink receives measurements of the transport. Add increments counters and moves gauges by delta,
+// Observe records a histogram sample.
+type MetricsSink interface {
+	Add(metric Metric, delta float64)
+	Observe(metric Metric, value float64)
+}
+
+type noopMetricsSink struct{}
+
+var _ MetricsSink = new(noopMetricsSink)
+
+func (noopMetricsSink) Add(Metric, float64) {}
+
+func (noopMetricsSink) Observe(Metric, float64) {}
+
+func incrementMetric(sink MetricsSink, metric Metric) {
+	sink.Add(metric, 1)
+}
+
+func decrementMetric(sink MetricsSink, metric Metric) {
+	sink.Add(metric, -1)
+}
+
+func observeDuration(sink MetricsSink, metric Metric, since time.Time) {
+	sink.Observe(metric, time.Since(since).Seconds())
+}
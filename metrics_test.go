@@ -0,0 +1,109 @@
+package star
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetricsSink struct {
+	m      sync.Mutex
+	values map[string]float64
+}
+
+func newRecordingMetricsSink() *recordingMetricsSink {
+	return &recordingMetricsSink{
+		values: map[string]float64{},
+	}
+}
+
+func (r *recordingMetricsSink) Add(metric Metric, delta float64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.values[metric.Name] += delta
+}
+
+func (r *recordingMetricsSink) Observe(metric Metric, value float64) {
+	r.Add(metric, 1)
+}
+
+func (r *recordingMetricsSink) value(metric Metric) float64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.values[metric.Name]
+}
+
+type discardAddressBook struct{}
+
+func (discardAddressBook) AddAddr(peer.ID, ma.Multiaddr, time.Duration) {}
+
+func TestProcessMessageRecordsMetrics(t *testing.T) {
+	metrics := newRecordingMetricsSink()
+	handshakeSubscription := newHandshakeSubscription()
+
+	// when
+	err := processMessage(discardAddressBook{}, handshakeSubscription,
+		[]byte(`["ws-peer","/dns4/localhost/tcp/443/wss/p2p-webrtc-star/ipfs/QmZeK8E6g5Ppxars6E8yhyi19aN2yaG2MQTPZwVGwyBnaJ"]`),
+		metrics, nil)
+	require.NoError(t, err)
+
+	err = processMessage(discardAddressBook{}, handshakeSubscription,
+		[]byte(`["ws-handshake",{"intentId":"signal-1","srcMultiaddr":"","dstMultiaddr":"","signal":{"type":"answer","sdp":""},"answer":true}]`),
+		metrics, nil)
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, float64(1), metrics.value(MetricPeerAnnouncements))
+	assert.Zero(t, metrics.value(MetricOffersReceived))
+}
+
+func TestTransportWithoutMetricsSink(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithMetricsSink(nil)
+	defer dialing.Close()
+
+	// when
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// then
+	_, err := dialed.OpenStream()
+	assert.NoError(t, err)
+}
+
+func TestTransportCountsDatagramChannels(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	metrics := newRecordingMetricsSink()
+	listening := newTestTransport(t, server).WithMetricsSink(metrics)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+	active := metrics.value(MetricDataChannelsActive)
+
+	// when
+	opened, err := dialed.(Conn).OpenDatagramChannel("game-state", nil)
+	require.NoError(t, err)
+	defer opened.Close()
+	_, err = accepted.(Conn).AcceptDatagramChannel()
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, active+1, metrics.value(MetricDataChannelsActive))
+
+	err = accepted.Close()
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return metrics.value(MetricDataChannelsActive) <= active
+	}, 5*time.Second, 50*time.Millisecond)
+}
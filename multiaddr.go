@@ -0,0 +1,175 @@
+package star
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multiaddr-net"
+	"net"
+	"strings"
+)
+
+var (
+	ErrInvalidStarAddr    = errors.New("invalid p2p-webrtc-star multiaddr")
+	ErrUnresolvedStarAddr = errors.New("p2p-webrtc-star multiaddr must be resolved first")
+)
+
+// StarAddr is a p2p-webrtc-star multiaddr split into the address of the signal server and an optional peer ID,
+// e.g. /dns4/star.example.com/tcp/443/wss/p2p-webrtc-star/ipfs/QmPeer. Both /ipfs and /p2p are accepted, as well
+// as /dnsaddr/example.com/p2p-webrtc-star, which resolves to a set of star servers.
+type StarAddr struct {
+	Signal ma.Multiaddr
+	Peer   peer.ID
+}
+
+// ParseStarAddr parses and validates a star multiaddr.
+func ParseStarAddr(s string) (StarAddr, error) {
+	addr, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return StarAddr{}, fmt.Errorf("%w: %v", ErrInvalidStarAddr, err)
+	}
+	return SplitStarAddr(addr)
+}
+
+// SplitStarAddr validates a star multiaddr and splits it into the signal server address and the peer ID.
+func SplitStarAddr(addr ma.Multiaddr) (StarAddr, error) {
+	if addr == nil {
+		return StarAddr{}, ErrInvalidStarAddr
+	}
+
+	var signalComponents []ma.Multiaddr
+	var peerID peer.ID
+	var starFound bool
+	var err error
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch {
+		case !starFound:
+			signalComponents = append(signalComponents, &c)
+			starFound = c.Protocol().Code == webRTCStarProtocolCode
+		case c.Protocol().Code == ma.P_P2P && peerID == "":
+			peerID, err = peer.IDB58Decode(c.Value())
+		default:
+			err = fmt.Errorf("unexpected component: %s", c.String())
+		}
+		return err == nil
+	})
+	if err != nil {
+		return StarAddr{}, fmt.Errorf("%w: %v", ErrInvalidStarAddr, err)
+	}
+
+	signalMultiaddr := ma.Join(signalComponents...)
+	if !starFound || !format.Matches(signalMultiaddr) && !dnsaddrFormat.Matches(signalMultiaddr) {
+		return StarAddr{}, fmt.Errorf("%w: %s", ErrInvalidStarAddr, addr)
+	}
+	return StarAddr{Signal: signalMultiaddr, Peer: peerID}, nil
+}
+
+// IsStarAddr checks if the multiaddr is a valid star multiaddr, with or without a peer ID.
+func IsStarAddr(addr ma.Multiaddr) bool {
+	_, err := SplitStarAddr(addr)
+	return err == nil
+}
+
+// NormalizeStarAddr returns the canonical form of a star multiaddr, e.g. /p2p is replaced with /ipfs.
+func NormalizeStarAddr(addr ma.Multiaddr) (ma.Multiaddr, error) {
+	starAddr, err := SplitStarAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return starAddr.Multiaddr(), nil
+}
+
+// SignalMultiaddrOption changes the multiaddr built by NewSignalMultiaddr.
+type SignalMultiaddrOption func(*signalMultiaddrOptions)
+
+type signalMultiaddrOptions struct {
+	dns6 bool
+}
+
+// SignalDNS6 resolves a DNS name to IPv6 addresses, so it's written as /dns6 instead of /dns4. IP addresses
+// aren't affected.
+func SignalDNS6() SignalMultiaddrOption {
+	return func(o *signalMultiaddrOptions) {
+		o.dns6 = true
+	}
+}
+
+// NewSignalMultiaddr builds the multiaddr of a signal server. The host may be an IPv4 or IPv6 address,
+// or a DNS name, which is resolved to IPv4 addresses unless SignalDNS6 is passed. Insecure addresses need
+// the ws protocol, which is registered by the WebSocket transport.
+func NewSignalMultiaddr(host string, port int, secure bool,
+	options ...SignalMultiaddrOption) (ma.Multiaddr, error) {
+	var o signalMultiaddrOptions
+	for _, option := range options {
+		option(&o)
+	}
+
+	var buf strings.Builder
+	if ip := net.ParseIP(host); ip == nil && o.dns6 {
+		buf.WriteString("/dns6/")
+	} else if ip == nil {
+		buf.WriteString("/dns4/")
+	} else if ip.To4() == nil {
+		buf.WriteString("/ip6/")
+	} else {
+		buf.WriteString("/ip4/")
+	}
+	buf.WriteString(host)
+	buf.WriteString(fmt.Sprintf("/tcp/%d", port))
+	if secure {
+		buf.WriteString("/" + wssProtocolName)
+	} else {
+		buf.WriteString("/ws")
+	}
+	buf.WriteString("/" + webRTCStarProtocolName)
+
+	addr, err := ma.NewMultiaddr(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStarAddr, err)
+	}
+	return addr, nil
+}
+
+// Multiaddr returns the signal server address with the peer ID appended, if it's set.
+func (a StarAddr) Multiaddr() ma.Multiaddr {
+	if a.Peer == "" {
+		return a.Signal
+	}
+
+	ipfsMultiaddr, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s", ipfsProtocolName, a.Peer.String()))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return a.Signal.Encapsulate(ipfsMultiaddr)
+}
+
+// SignalURL returns the WebSocket URL of the signal server. Addresses with /dnsaddr have to be resolved first.
+func (a StarAddr) SignalURL(urlPath string) (string, error) {
+	if isDnsaddr(a.Signal) {
+		return "", ErrUnresolvedStarAddr
+	}
+
+	websocketAddr := a.Signal.Decapsulate(protocolMultiaddr)
+	var buf strings.Builder
+	buf.WriteString(readProtocolForSignalURL(websocketAddr))
+
+	_, hostPort, err := manet.DialArgs(websocketAddr)
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString(hostPort)
+	buf.WriteString(urlPath)
+	return buf.String(), nil
+}
+
+func (a StarAddr) String() string {
+	return a.Multiaddr().String()
+}
+
+func readProtocolForSignalURL(maddr ma.Multiaddr) string {
+	if _, err := maddr.ValueForProtocol(wssProtocolCode); err == nil {
+		return "wss://"
+	}
+	return "ws://"
+}
@@ -0,0 +1,123 @@
+package star
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const testPeerID = "QmZeK8E6g5Ppxars6E8yhyi19aN2yaG2MQTPZwVGwyBnaJ"
+
+func TestParseStarAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr      string
+		signal    string
+		signalURL string
+	}{
+		{
+			addr:      "/dns4/star.example.com/tcp/443/wss/p2p-webrtc-star/ipfs/" + testPeerID,
+			signal:    "/dns4/star.example.com/tcp/443/wss/p2p-webrtc-star",
+			signalURL: "wss://star.example.com:443",
+		},
+		{
+			addr:      "/dns6/star.example.com/tcp/443/wss/p2p-webrtc-star/p2p/" + testPeerID,
+			signal:    "/dns6/star.example.com/tcp/443/wss/p2p-webrtc-star",
+			signalURL: "wss://star.example.com:443",
+		},
+		{
+			addr:      "/ip6/::1/tcp/9090/wss/p2p-webrtc-star/p2p/" + testPeerID,
+			signal:    "/ip6/::1/tcp/9090/wss/p2p-webrtc-star",
+			signalURL: "wss://[::1]:9090",
+		},
+	} {
+		// when
+		starAddr, err := ParseStarAddr(tc.addr)
+
+		// then
+		require.NoError(t, err, tc.addr)
+		assert.Equal(t, tc.signal, starAddr.Signal.String())
+		assert.Equal(t, testPeerID, starAddr.Peer.String())
+
+		signalURL, err := starAddr.SignalURL("")
+		require.NoError(t, err, tc.addr)
+		assert.Equal(t, tc.signalURL, signalURL)
+	}
+}
+
+func TestParseStarAddrRejectsInvalidAddresses(t *testing.T) {
+	for _, addr := range []string{
+		"/dns4/star.example.com/tcp/443/wss",
+		"/dns4/star.example.com/tcp/443/p2p-webrtc-star",
+		"/dns4/star.example.com/tcp/443/wss/p2p-webrtc-star/ipfs/" + testPeerID + "/ipfs/" + testPeerID,
+		"/dns4/star.example.com/tcp/443/wss/p2p-webrtc-star/tcp/80",
+		"not a multiaddr",
+	} {
+		// when
+		_, err := ParseStarAddr(addr)
+
+		// then
+		assert.True(t, errors.Is(err, ErrInvalidStarAddr), addr)
+	}
+}
+
+func TestDnsaddrStarAddrNeedsResolution(t *testing.T) {
+	starAddr, err := ParseStarAddr("/dnsaddr/star.example.com/tcp/443/wss/p2p-webrtc-star")
+	require.NoError(t, err)
+
+	// when
+	_, err = starAddr.SignalURL("")
+
+	// then
+	assert.Equal(t, ErrUnresolvedStarAddr, err)
+}
+
+func TestNormalizeStarAddr(t *testing.T) {
+	starAddr, err := ParseStarAddr("/ip6/0:0:0:0:0:0:0:1/tcp/443/wss/p2p-webrtc-star/p2p/" + testPeerID)
+	require.NoError(t, err)
+
+	// when
+	normalized, err := NormalizeStarAddr(starAddr.Multiaddr())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "/ip6/::1/tcp/443/wss/p2p-webrtc-star/ipfs/"+testPeerID, normalized.String())
+}
+
+func TestNewSignalMultiaddr(t *testing.T) {
+	for host, expected := range map[string]string{
+		"star.example.com": "/dns4/star.example.com/tcp/443/wss/p2p-webrtc-star",
+		"127.0.0.1":        "/ip4/127.0.0.1/tcp/443/wss/p2p-webrtc-star",
+		"::1":              "/ip6/::1/tcp/443/wss/p2p-webrtc-star",
+	} {
+		// when
+		signalMultiaddr, err := NewSignalMultiaddr(host, 443, true)
+
+		// then
+		require.NoError(t, err, host)
+		assert.Equal(t, expected, signalMultiaddr.String())
+		assert.True(t, IsStarAddr(signalMultiaddr), host)
+	}
+}
+
+func TestNewSignalMultiaddrWithIPv6Name(t *testing.T) {
+	for host, expected := range map[string]string{
+		"star.example.com": "/dns6/star.example.com/tcp/443/wss/p2p-webrtc-star",
+		"::1":              "/ip6/::1/tcp/443/wss/p2p-webrtc-star",
+		"127.0.0.1":        "/ip4/127.0.0.1/tcp/443/wss/p2p-webrtc-star",
+	} {
+		// when
+		signalMultiaddr, err := NewSignalMultiaddr(host, 443, true, SignalDNS6())
+
+		// then
+		require.NoError(t, err, host)
+		assert.Equal(t, expected, signalMultiaddr.String())
+		assert.True(t, IsStarAddr(signalMultiaddr), host)
+	}
+
+	signalMultiaddr, err := NewSignalMultiaddr("star.example.com", 443, true, SignalDNS6())
+	require.NoError(t, err)
+	signalURL, err := StarAddr{Signal: signalMultiaddr}.SignalURL("/socket.io/")
+	require.NoError(t, err)
+	assert.Equal(t, "wss://star.example.com:443/socket.io/", signalURL)
+}
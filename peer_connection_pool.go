@@ -0,0 +1,186 @@
+package star
+
+import (
+	"github.com/pion/webrtc/v2"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPooledPeerConnectionMaxAge = 30 * time.Second
+	peerConnectionPoolRetryInterval   = 5 * time.Second
+)
+
+// PeerConnectionPoolConfiguration enables a pool of prewarmed peer connections. Creating a peer connection
+// gathers ICE candidates, which takes a while with STUN or TURN servers, so dials and accepted offers take
+// a pooled one instead. Dials which override the ICE configuration don't use the pool.
+type PeerConnectionPoolConfiguration struct {
+	// Size is the number of peer connections kept ready (default: 0, the pool is disabled).
+	Size int
+
+	// MaxAge limits the idle time of a pooled peer connection, so that server reflexive candidates don't outlive
+	// their NAT mappings (default: 30s).
+	MaxAge time.Duration
+}
+
+type pooledPeerConnection struct {
+	peerConnection *webrtc.PeerConnection
+	createdAt      time.Time
+}
+
+// peerConnectionPool keeps up to Size peer connections with candidates gathered, refills them in the background
+// and replaces them once they are older than MaxAge.
+type peerConnectionPool struct {
+	configuration       PeerConnectionPoolConfiguration
+	webRTCConfiguration webrtc.Configuration
+	metrics             MetricsSink
+
+	m    sync.Mutex
+	idle []pooledPeerConnection
+
+	refillCh chan struct{}
+	closedCh chan struct{}
+	doneCh   chan struct{}
+}
+
+func newPeerConnectionPool(configuration PeerConnectionPoolConfiguration, webRTCConfiguration webrtc.Configuration,
+	metrics MetricsSink) *peerConnectionPool {
+	if configuration.MaxAge <= 0 {
+		configuration.MaxAge = defaultPooledPeerConnectionMaxAge
+	}
+
+	p := &peerConnectionPool{
+		configuration:       configuration,
+		webRTCConfiguration: webRTCConfiguration,
+		metrics:             metrics,
+		refillCh:            make(chan struct{}, 1),
+		closedCh:            make(chan struct{}),
+		doneCh:              make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// get returns a prewarmed peer connection or nil, if there is none.
+func (p *peerConnectionPool) get() *webrtc.PeerConnection {
+	defer notify(p.refillCh)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for len(p.idle) > 0 {
+		pooled := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		decrementMetric(p.metrics, MetricPeerConnectionsPooled)
+
+		if time.Since(pooled.createdAt) < p.configuration.MaxAge {
+			return pooled.peerConnection
+		}
+		closePooledPeerConnection(pooled.peerConnection)
+	}
+	return nil
+}
+
+func (p *peerConnectionPool) run() {
+	defer close(p.doneCh)
+
+	for {
+		retry := p.fill()
+		wait := p.expireIdle()
+		if retry && wait > peerConnectionPoolRetryInterval {
+			wait = peerConnectionPoolRetryInterval
+		} else if !retry && p.idleCount() < p.configuration.Size {
+			wait = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.refillCh:
+		case <-timer.C:
+		case <-p.closedCh:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// fill creates peer connections until the pool is full. It returns true, if it failed and has to be retried.
+func (p *peerConnectionPool) fill() bool {
+	for p.idleCount() < p.configuration.Size {
+		select {
+		case <-p.closedCh:
+			return false
+		default:
+		}
+
+		peerConnection, err := webrtcapi.NewPeerConnection(p.webRTCConfiguration)
+		if err != nil {
+			logger.Warningf("Can't prewarm peer connection: %v", err)
+			return true
+		}
+
+		p.m.Lock()
+		select {
+		case <-p.closedCh:
+			p.m.Unlock()
+			closePooledPeerConnection(peerConnection)
+			return false
+		default:
+		}
+		p.idle = append(p.idle, pooledPeerConnection{peerConnection: peerConnection, createdAt: time.Now()})
+		incrementMetric(p.metrics, MetricPeerConnectionsPooled)
+		p.m.Unlock()
+	}
+	return false
+}
+
+// expireIdle closes the peer connections which are too old and returns the time until the next one expires.
+func (p *peerConnectionPool) expireIdle() time.Duration {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	wait := p.configuration.MaxAge
+	fresh := p.idle[:0]
+	for _, pooled := range p.idle {
+		age := time.Since(pooled.createdAt)
+		if age >= p.configuration.MaxAge {
+			decrementMetric(p.metrics, MetricPeerConnectionsPooled)
+			closePooledPeerConnection(pooled.peerConnection)
+			continue
+		}
+		if p.configuration.MaxAge-age < wait {
+			wait = p.configuration.MaxAge - age
+		}
+		fresh = append(fresh, pooled)
+	}
+	p.idle = fresh
+	return wait
+}
+
+func (p *peerConnectionPool) idleCount() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return len(p.idle)
+}
+
+func (p *peerConnectionPool) close() {
+	p.m.Lock()
+	close(p.closedCh)
+	idle := p.idle
+	p.idle = nil
+	p.m.Unlock()
+
+	<-p.doneCh
+	for _, pooled := range idle {
+		decrementMetric(p.metrics, MetricPeerConnectionsPooled)
+		closePooledPeerConnection(pooled.peerConnection)
+	}
+}
+
+func closePooledPeerConnection(peerConnection *webrtc.PeerConnection) {
+	err := peerConnection.Close()
+	if err != nil {
+		logger.Warningf("Can't close pooled peer connection: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPeerConnectionPoolReplacesExpiredPeerConnections(t *testing.T) {
+	metrics := newRecordingMetricsSink()
+	pool := newPeerConnectionPool(PeerConnectionPoolConfiguration{Size: 2, MaxAge: 500 * time.Millisecond},
+		webrtc.Configuration{}, metrics)
+	defer pool.close()
+
+	require.Eventually(t, func() bool { return pool.idleCount() == 2 }, 5*time.Second, 10*time.Millisecond)
+	pool.m.Lock()
+	expiring := pool.idle[0].peerConnection
+	pool.m.Unlock()
+
+	isPooled := func(peerConnection *webrtc.PeerConnection) bool {
+		pool.m.Lock()
+		defer pool.m.Unlock()
+		for _, pooled := range pool.idle {
+			if pooled.peerConnection == peerConnection {
+				return true
+			}
+		}
+		return false
+	}
+
+	// when
+	taken := pool.get()
+
+	// then
+	require.NotNil(t, taken)
+	defer taken.Close()
+	assert.NotEqual(t, expiring, taken)
+	require.Eventually(t, func() bool { return pool.idleCount() == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(2), metrics.value(MetricPeerConnectionsPooled))
+
+	require.Eventually(t, func() bool { return !isPooled(expiring) && pool.idleCount() == 2 }, 5*time.Second,
+		10*time.Millisecond)
+	assert.Equal(t, float64(2), metrics.value(MetricPeerConnectionsPooled))
+}
+
+func TestTransportUsesPeerConnectionPool(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	metrics := newRecordingMetricsSink()
+	pool := PeerConnectionPoolConfiguration{Size: 1}
+	listening := newTestTransport(t, server).WithPeerConnectionPool(pool).WithMetricsSink(metrics)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithPeerConnectionPool(pool)
+	defer dialing.Close()
+
+	// when
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// then
+	_, err := dialed.OpenStream()
+	require.NoError(t, err)
+	_, err = accepted.AcceptStream()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return metrics.value(MetricPeerConnectionsPooled) == 1 }, 5*time.Second,
+		10*time.Millisecond)
+	require.NoError(t, listening.Close())
+	assert.Equal(t, float64(0), metrics.value(MetricPeerConnectionsPooled))
+}
+
+func BenchmarkHandshake(b *testing.B) {
+	b.Run("new peer connections", func(b *testing.B) {
+		benchmarkHandshake(b, PeerConnectionPoolConfiguration{})
+	})
+	b.Run("pooled peer connections", func(b *testing.B) {
+		benchmarkHandshake(b, PeerConnectionPoolConfiguration{Size: 2})
+	})
+}
+
+func benchmarkHandshake(b *testing.B, pool PeerConnectionPoolConfiguration) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(b, server).WithPeerConnectionPool(pool)
+	defer listening.Close()
+	dialing := newTestTransport(b, server).WithPeerConnectionPool(pool)
+	defer dialing.Close()
+
+	listener, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(b, err)
+	acceptedCh := make(chan io.Closer)
+	go func() {
+		for {
+			accepted, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			acceptedCh <- accepted
+		}
+	}()
+
+	dial := func() {
+		for {
+			dialed, err := dialing.Dial(context.Background(), server.signalMultiaddr(), listening.peerID)
+			if errors.Is(err, ErrPeerNotPresent) {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			require.NoError(b, err)
+			(<-acceptedCh).Close()
+			dialed.Close()
+			return
+		}
+	}
+	dial() // wait until the listener joined and the pools are filled
+	time.Sleep(time.Second)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dial()
+	}
+}
@@ -0,0 +1,85 @@
+package promsink
+
+import (
+	"fmt"
+	"github.com/mtojek/go-libp2p-webrtc-star"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultNamespace = "p2p_webrtc_star"
+
+type Sink struct {
+	counters   map[string]prometheus.Counter
+	gauges     map[string]prometheus.Gauge
+	histograms map[string]prometheus.Histogram
+}
+
+var _ star.MetricsSink = new(Sink)
+
+func New(registerer prometheus.Registerer) (*Sink, error) {
+	return NewWithNamespace(registerer, defaultNamespace)
+}
+
+func NewWithNamespace(registerer prometheus.Registerer, namespace string) (*Sink, error) {
+	sink := &Sink{
+		counters:   map[string]prometheus.Counter{},
+		gauges:     map[string]prometheus.Gauge{},
+		histograms: map[string]prometheus.Histogram{},
+	}
+
+	for _, metric := range star.Metrics {
+		var collector prometheus.Collector
+		switch metric.Kind {
+		case star.CounterMetric:
+			counter := prometheus.NewCounter(prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      metric.Name,
+				Help:      metric.Help,
+			})
+			sink.counters[metric.Name] = counter
+			collector = counter
+		case star.GaugeMetric:
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      metric.Name,
+				Help:      metric.Help,
+			})
+			sink.gauges[metric.Name] = gauge
+			collector = gauge
+		case star.HistogramMetric:
+			histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      metric.Name,
+				Help:      metric.Help,
+				Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+			})
+			sink.histograms[metric.Name] = histogram
+			collector = histogram
+		default:
+			return nil, fmt.Errorf(`unsupported kind of metric "%s"`, metric.Name)
+		}
+
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+func (s *Sink) Add(metric star.Metric, delta float64) {
+	if counter, ok := s.counters[metric.Name]; ok {
+		counter.Add(delta)
+		return
+	}
+
+	if gauge, ok := s.gauges[metric.Name]; ok {
+		gauge.Add(delta)
+	}
+}
+
+func (s *Sink) Observe(metric star.Metric, value float64) {
+	if histogram, ok := s.histograms[metric.Name]; ok {
+		histogram.Observe(value)
+	}
+}
@@ -0,0 +1,68 @@
+package promsink
+
+import (
+	"github.com/mtojek/go-libp2p-webrtc-star"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSinkRegistersAllMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	// when
+	_, err := New(registry)
+	require.NoError(t, err)
+
+	// then
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.Len(t, families, len(star.Metrics))
+}
+
+func TestSinkUpdatesMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink, err := New(registry)
+	require.NoError(t, err)
+
+	// when
+	sink.Add(star.MetricOffersSent, 1)
+	sink.Add(star.MetricOffersSent, 1)
+	sink.Add(star.MetricStreamBytesSent, 1024)
+	sink.Add(star.MetricPeerConnectionsActive, 1)
+	sink.Add(star.MetricPeerConnectionsActive, 1)
+	sink.Add(star.MetricPeerConnectionsActive, -1)
+	sink.Observe(star.MetricHandshakeDuration, 0.5)
+	sink.Observe(star.MetricHandshakeDuration, 1.5)
+
+	// then
+	assert.Equal(t, float64(2), testutil.ToFloat64(sink.counters[star.MetricOffersSent.Name]))
+	assert.Equal(t, float64(1024), testutil.ToFloat64(sink.counters[star.MetricStreamBytesSent.Name]))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sink.gauges[star.MetricPeerConnectionsActive.Name]))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == defaultNamespace+"_"+star.MetricHandshakeDuration.Name {
+			histogram := family.GetMetric()[0].GetHistogram()
+			assert.Equal(t, uint64(2), histogram.GetSampleCount())
+			assert.Equal(t, float64(2), histogram.GetSampleSum())
+			return
+		}
+	}
+	t.Fatal("handshake duration histogram not found")
+}
+
+func TestSinkRejectsDuplicateRegistration(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	_, err := New(registry)
+	require.NoError(t, err)
+
+	// when
+	_, err = New(registry)
+
+	// then
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package star
 
 import (
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multiaddr-dns"
 	"github.com/multiformats/go-multiaddr-fmt"
 )
 
@@ -26,6 +27,7 @@ var (
 	format = mafmt.And(mafmt.TCP,
 		mafmt.Or(mafmt.Base(wssProtocol.Code), mafmt.Base(wsProtocolCode)),
 		mafmt.Base(webRTCStarProtocolCode))
+	dnsaddrFormat = mafmt.And(mafmt.Base(madns.P_DNSADDR), mafmt.Base(webRTCStarProtocolCode))
 )
 
 func init() {
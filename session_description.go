@@ -0,0 +1,41 @@
+package star
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pion/webrtc/v2"
+)
+
+type SessionDescriptionOrigin int
+
+const (
+	LocalSessionDescription SessionDescriptionOrigin = iota
+	RemoteSessionDescription
+)
+
+func (o SessionDescriptionOrigin) String() string {
+	if o == RemoteSessionDescription {
+		return "remote"
+	}
+	return "local"
+}
+
+// SessionDescriptionHook is called with every local description before it is sent to the star
+// and with every remote description before it is applied. Returning an error aborts the handshake, an accepting
+// peer rejects the offer, so the dial fails with ErrHandshakeRejected.
+type SessionDescriptionHook func(peerID peer.ID, origin SessionDescriptionOrigin,
+	description webrtc.SessionDescription) (webrtc.SessionDescription, error)
+
+func (s *signal) transformSessionDescription(peerID peer.ID, origin SessionDescriptionOrigin,
+	description webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if s.sessionDescriptionHook == nil {
+		return description, nil
+	}
+
+	logger.Debugf("Transform %s session description (peerID: %s, type: %s)", origin, peerID, description.Type)
+	transformed, err := s.sessionDescriptionHook(peerID, origin, description)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("%s session description rejected by hook: %w", origin, err)
+	}
+	return transformed, nil
+}
@@ -0,0 +1,155 @@
+package star
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	deflateSignalEncoding = "deflate"
+
+	maxExpandedSignalSize = 1 << 20
+)
+
+var errExpandedSignalTooBig = errors.New("expanded session description too big")
+
+// compactSignal prepares the session description of an outgoing handshake message. Duplicate candidates are
+// always removed; the description is compressed only if the remote peer advertised support for it.
+func (s *signal) compactSignal(remotePeerID peer.ID, data *handshakeData) error {
+	if !s.transport.signalConfiguration.CompactSessionDescriptions {
+		return nil
+	}
+
+	data.SignalEncodings = []string{deflateSignalEncoding}
+	data.Signal.SDP = dedupCandidates(data.Signal.SDP)
+	if !s.supportsCompactSignal(remotePeerID) {
+		return nil
+	}
+
+	compact, err := deflateSignal(data.Signal.SDP)
+	if err != nil {
+		return err
+	}
+	data.Signal.SDP = ""
+	data.CompactSignal = compact
+	return nil
+}
+
+// rememberSignalEncodings records whether the remote peer can expand compressed session descriptions.
+func (s *signal) rememberSignalEncodings(remotePeerID peer.ID, data handshakeData) {
+	if !containsString(data.SignalEncodings, deflateSignalEncoding) {
+		return
+	}
+
+	s.mCompactPeers.Lock()
+	s.compactPeers[remotePeerID] = true
+	s.mCompactPeers.Unlock()
+}
+
+// forgetSignalEncodings drops what the remote peer advertised once its handshake failed or its connection was
+// closed. The next handshake advertises the encodings again.
+func (s *signal) forgetSignalEncodings(remotePeerID peer.ID) {
+	s.mCompactPeers.Lock()
+	delete(s.compactPeers, remotePeerID)
+	s.mCompactPeers.Unlock()
+}
+
+func (s *signal) supportsCompactSignal(remotePeerID peer.ID) bool {
+	s.mCompactPeers.Lock()
+	defer s.mCompactPeers.Unlock()
+	return s.compactPeers[remotePeerID]
+}
+
+// expandSignal restores the session description of a compressed handshake message.
+func (data *handshakeData) expandSignal() error {
+	if data.CompactSignal == "" {
+		return nil
+	}
+
+	sdp, err := inflateSignal(data.CompactSignal)
+	if err != nil {
+		return err
+	}
+	data.Signal.SDP = sdp
+	data.CompactSignal = ""
+	return nil
+}
+
+func deflateSignal(sdp string) (string, error) {
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriter(&buffer, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = writer.Write([]byte(sdp))
+	if err != nil {
+		return "", err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+func inflateSignal(compact string) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(compact)
+	if err != nil {
+		return "", err
+	}
+
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+
+	sdp, err := ioutil.ReadAll(io.LimitReader(reader, maxExpandedSignalSize+1))
+	if err != nil {
+		return "", err
+	} else if len(sdp) > maxExpandedSignalSize {
+		return "", errExpandedSignalTooBig
+	}
+	return string(sdp), nil
+}
+
+// dedupCandidates removes candidates which repeat the transport address and type of an earlier candidate
+// of the same media section. Such duplicates appear for every local interface behind the same NAT.
+func dedupCandidates(sdp string) string {
+	lines := strings.SplitAfter(sdp, "\n")
+	result := make([]string, 0, len(lines))
+	seen := map[string]bool{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			seen = map[string]bool{}
+		}
+
+		if key, ok := candidateKey(line); ok {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "")
+}
+
+// candidateKey skips the foundation and priority of a candidate: a=candidate:<foundation> <component>
+// <transport> <priority> <address> <port> typ <type>.
+func candidateKey(line string) (string, bool) {
+	if !strings.HasPrefix(line, "a=candidate:") {
+		return "", false
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return "", false
+	}
+	return strings.Join([]string{fields[1], fields[2], fields[4], fields[5], fields[7]}, " "), true
+}
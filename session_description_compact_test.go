@@ -0,0 +1,141 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCompactSDP = "v=0\r\n" +
+	"m=application 9 DTLS/SCTP 5000\r\n" +
+	"a=candidate:1 1 udp 2130706431 10.0.0.2 50000 typ host\r\n" +
+	"a=candidate:2 1 udp 1694498815 1.2.3.4 50000 typ srflx raddr 0.0.0.0 rport 50000\r\n" +
+	"a=candidate:3 1 udp 1694498815 1.2.3.4 50000 typ srflx raddr 0.0.0.0 rport 50001\r\n" +
+	"m=application 9 DTLS/SCTP 5001\r\n" +
+	"a=candidate:4 1 udp 1694498815 1.2.3.4 50000 typ srflx raddr 0.0.0.0 rport 50000\r\n"
+
+func TestDedupCandidates(t *testing.T) {
+	// when
+	sdp := dedupCandidates(testCompactSDP)
+
+	// then
+	assert.Equal(t, 3, strings.Count(sdp, "a=candidate:"))
+	assert.NotContains(t, sdp, "a=candidate:3 ")
+	assert.Contains(t, sdp, "a=candidate:4 ")
+}
+
+func TestExpandSignal(t *testing.T) {
+	compact, err := deflateSignal(testCompactSDP)
+	require.NoError(t, err)
+	data := handshakeData{CompactSignal: compact}
+
+	// when
+	err = data.expandSignal()
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, testCompactSDP, data.Signal.SDP)
+	assert.Empty(t, data.CompactSignal)
+}
+
+func TestExpandSignalRejectsOversizedDescription(t *testing.T) {
+	compact, err := deflateSignal(strings.Repeat("a", maxExpandedSignalSize+1))
+	require.NoError(t, err)
+	data := handshakeData{CompactSignal: compact}
+
+	// when
+	err = data.expandSignal()
+
+	// then
+	assert.Equal(t, errExpandedSignalTooBig, err)
+}
+
+func TestTransportCompactsSessionDescriptions(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	configuration := server.signalConfiguration()
+	configuration.CompactSessionDescriptions = true
+	listening := newTestTransport(t, server).WithSignalConfiguration(configuration)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithSignalConfiguration(configuration)
+	defer dialing.Close()
+
+	// when
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// then
+	assert.False(t, accepted.IsClosed())
+	assert.False(t, dialed.IsClosed())
+
+	var compactMessages int
+	for len(server.receivedCh) > 0 {
+		if strings.Contains(<-server.receivedCh, `"compactSignal":`) {
+			compactMessages++
+		}
+	}
+	assert.Equal(t, 1, compactMessages, "only the answer is compacted")
+}
+
+func TestTransportForgetsSignalEncodingsOfClosedConnections(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	configuration := server.signalConfiguration()
+	configuration.CompactSessionDescriptions = true
+	listening := newTestTransport(t, server).WithSignalConfiguration(configuration)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithSignalConfiguration(configuration)
+	defer dialing.Close()
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	signal, err := dialing.getOrRegisterSignal(server.signalMultiaddr())
+	require.NoError(t, err)
+	require.True(t, signal.supportsCompactSignal(listening.peerID))
+
+	// when
+	err = dialed.Close()
+
+	// then
+	require.NoError(t, err)
+	assert.False(t, signal.supportsCompactSignal(listening.peerID))
+}
+
+func TestTransportForgetsSignalEncodingsOfFailedHandshakes(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	configuration := server.signalConfiguration()
+	configuration.CompactSessionDescriptions = true
+	listeningHook := &recordingSessionDescriptionHook{err: errors.New("offer rejected")}
+	listening := newTestTransport(t, server).WithSignalConfiguration(configuration).
+		WithSessionDescriptionHook(listeningHook.transform)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithSignalConfiguration(configuration)
+	defer dialing.Close()
+
+	listener, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+	go listener.Accept()
+
+	// when
+	for {
+		_, err = dialing.Dial(context.Background(), server.signalMultiaddr(), listening.peerID)
+		if !errors.Is(err, ErrPeerNotPresent) { // the listener may not have joined yet
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// then
+	require.True(t, errors.Is(err, ErrHandshakeRejected), "unexpected error: %v", err)
+	signal, err := listening.getOrRegisterSignal(server.signalMultiaddr())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { // the offer is rejected before the handshake is cleaned up
+		return !signal.supportsCompactSignal(dialing.peerID)
+	}, 5*time.Second, 10*time.Millisecond)
+}
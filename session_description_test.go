@@ -0,0 +1,144 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSessionDescriptionHook marks every local description and records every description it sees.
+type recordingSessionDescriptionHook struct {
+	name string
+	err  error
+
+	m            sync.Mutex
+	descriptions []string
+	remoteSDPs   []string
+}
+
+func (h *recordingSessionDescriptionHook) transform(_ peer.ID, origin SessionDescriptionOrigin,
+	description webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.descriptions = append(h.descriptions, fmt.Sprintf("%s %s", origin, description.Type))
+	if h.err != nil {
+		return webrtc.SessionDescription{}, h.err
+	}
+
+	if origin == LocalSessionDescription {
+		description.SDP += fmt.Sprintf("a=x-hooked:%s\r\n", h.name)
+	} else {
+		h.remoteSDPs = append(h.remoteSDPs, description.SDP)
+	}
+	return description, nil
+}
+
+func (h *recordingSessionDescriptionHook) recorded() ([]string, []string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.descriptions, h.remoteSDPs
+}
+
+func TestTransportPassesSessionDescriptionsToHook(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listeningHook := &recordingSessionDescriptionHook{name: "listener"}
+	listening := newTestTransport(t, server).WithSessionDescriptionHook(listeningHook.transform)
+	defer listening.Close()
+	dialingHook := &recordingSessionDescriptionHook{name: "dialer"}
+	dialing := newTestTransport(t, server).WithSessionDescriptionHook(dialingHook.transform)
+	defer dialing.Close()
+
+	// when
+	connectTestTransports(t, server, listening, dialing)
+
+	// then
+	descriptions, remoteSDPs := dialingHook.recorded()
+	require.True(t, len(descriptions) >= 2)
+	descriptions = descriptions[len(descriptions)-2:] // offers sent before the listener joined are rejected
+	assert.Equal(t, []string{"local offer", "remote answer"}, descriptions)
+	require.Len(t, remoteSDPs, 1)
+	assert.True(t, strings.Contains(remoteSDPs[0], "a=x-hooked:listener"), "answer not rewritten")
+
+	descriptions, remoteSDPs = listeningHook.recorded()
+	assert.Equal(t, []string{"remote offer", "local answer"}, descriptions)
+	require.Len(t, remoteSDPs, 1)
+	assert.True(t, strings.Contains(remoteSDPs[0], "a=x-hooked:dialer"), "offer not rewritten")
+}
+
+func TestTransportDialAbortedByHook(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	hookErr := errors.New("offer rejected")
+	dialingHook := &recordingSessionDescriptionHook{err: hookErr}
+	dialing := newTestTransport(t, server).WithSessionDescriptionHook(dialingHook.transform)
+	defer dialing.Close()
+
+	_, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+
+	// when
+	_, err = dialing.Dial(context.Background(), server.signalMultiaddr(), listening.peerID)
+
+	// then
+	assert.True(t, errors.Is(err, hookErr), "unexpected error: %v", err)
+	descriptions, _ := dialingHook.recorded()
+	assert.Equal(t, []string{"local offer"}, descriptions)
+}
+
+func TestTransportAcceptAbortedByHook(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listeningHook := &recordingSessionDescriptionHook{err: errors.New("offer rejected")}
+	listening := newTestTransport(t, server).WithSessionDescriptionHook(listeningHook.transform)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	listener, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+	acceptedCh := make(chan struct{})
+	go func() {
+		_, err := listener.Accept()
+		if err == nil {
+			close(acceptedCh)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// when
+	for {
+		_, err = dialing.Dial(ctx, server.signalMultiaddr(), listening.peerID)
+		if !errors.Is(err, ErrPeerNotPresent) { // the listener may not have joined yet
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// then
+	assert.True(t, errors.Is(err, ErrHandshakeRejected), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "offer rejected")
+	descriptions, _ := listeningHook.recorded()
+	assert.Equal(t, []string{"remote offer"}, descriptions)
+	select {
+	case <-acceptedCh:
+		assert.Fail(t, "connection accepted")
+	default:
+	}
+}
@@ -2,16 +2,21 @@ package star
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"github.com/libp2p/go-libp2p-core/mux"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/transport"
 	ma "github.com/multiformats/go-multiaddr"
-	"github.com/multiformats/go-multiaddr-net"
 	"github.com/pion/datachannel"
 	"github.com/pion/webrtc/v2"
-	"strings"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 )
 
 const (
@@ -20,30 +25,90 @@ const (
 )
 
 type signal struct {
-	transport transport.Transport
+	transport *Transport
 
-	peerID          peer.ID
-	peerMultiaddr   ma.Multiaddr
-	signalMultiaddr ma.Multiaddr
+	peerID    peer.ID
+	endpoints *starEndpoints
 
-	acceptedCh      <-chan transport.CapableConn
-	handshakeDataCh chan<- handshakeData
+	handshakeQueue *handshakeQueue
+
+	compactPeers  map[peer.ID]bool
+	mCompactPeers sync.Mutex
+
+	trackHandoffs  map[*webrtc.PeerConnection]*trackHandoff
+	mTrackHandoffs sync.Mutex
 
 	handshakeSubscription *handshakeSubscription
 	webRTCConfiguration   webrtc.Configuration
+	peerConnectionPool    *peerConnectionPool
+	dataChannel           DataChannelConfiguration
 	multiplexer           mux.Multiplexer
 
-	stopCh chan<- struct{}
+	sessionDescriptionHook SessionDescriptionHook
+	iceRecoveryWindow      time.Duration
+	idleTimeout            time.Duration
+	keepalive              KeepaliveConfiguration
+	metrics                MetricsSink
+	events                 *eventEmitters
+
+	joinCh   chan struct{}
+	joinOnce sync.Once
+
+	stopClient   context.CancelFunc
+	clientDoneCh <-chan struct{}
+	closedCh     chan struct{}
+	closeOnce    sync.Once
 }
 
 type SignalConfiguration struct {
 	URLPath string
+
+	// Header is sent with every WebSocket handshake. Headers returned by HeaderProvider, which is called
+	// on every (re)connect, take precedence.
+	Header         http.Header
+	HeaderProvider HeaderProvider
+
+	TLSConfig        *tls.Config
+	Proxy            func(*http.Request) (*url.URL, error)
+	HandshakeTimeout time.Duration
+
+	// Dialer is used as a base for connections to the signal server (default: websocket.DefaultDialer).
+	Dialer    *websocket.Dialer
+	NetDialer *net.Dialer
+
+	// Transport selects the Engine.IO transport (default: WebSocket). With polling, UpgradeTransport enables
+	// the upgrade to WebSocket once the server offers it.
+	Transport        SignalTransport
+	UpgradeTransport bool
+
+	// JoinOnListen defers ss-join until the first Listen or Dial for the address, so idle sessions don't
+	// announce the node. The reference star routes answers only to joined multiaddrs, so a dial joins as well,
+	// but incoming offers are dropped until Listen is called.
+	JoinOnListen bool
+
+	// MaxMessageSize limits the size of messages read from the signal server (default: 8192 bytes). Offers with
+	// many candidates may exceed the default.
+	MaxMessageSize int64
+
+	// CompactSessionDescriptions removes duplicate ICE candidates from outgoing session descriptions and
+	// compresses them for peers which advertise support for it.
+	CompactSessionDescriptions bool
+}
+
+func (c SignalConfiguration) readLimit() int64 {
+	if c.MaxMessageSize > 0 {
+		return c.MaxMessageSize
+	}
+	return maxMessageSize
 }
 
 type sessionProperties struct {
-	SID                string `json:"sid"`
-	PingIntervalMillis int64  `json:"pingInterval"`
-	PingTimeoutMillis  int64  `json:"pingTimeout"`
+	SID                string   `json:"sid"`
+	PingIntervalMillis int64    `json:"pingInterval"`
+	PingTimeoutMillis  int64    `json:"pingTimeout"`
+	Upgrades           []string `json:"upgrades"`
+
+	Joined bool `json:"-"`
 }
 
 var webrtcapi *webrtc.API
@@ -51,77 +116,134 @@ var webrtcapi *webrtc.API
 func init() {
 	settingEngine := webrtc.SettingEngine{}
 	settingEngine.DetachDataChannels()
-	webrtcapi = webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	webrtcapi = webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine), webrtc.WithMediaEngine(mediaEngine))
 }
 
-func newSignal(transport transport.Transport, signalMultiaddr ma.Multiaddr, addressBook addressBook, peerID peer.ID,
-	signalConfiguration SignalConfiguration, webRTCConfiguration webrtc.Configuration, multiplexer mux.Multiplexer) (*signal, error) {
-	url, err := createSignalURL(signalMultiaddr, signalConfiguration)
-	if err != nil {
-		return nil, err
-	}
-
-	peerMultiaddr, err := createPeerMultiaddr(signalMultiaddr, peerID)
+func newSignal(transport *Transport, signalMultiaddr ma.Multiaddr) (*signal, error) {
+	endpoints, err := newStarEndpoints(signalMultiaddr, transport.peerID, transport.signalConfiguration,
+		transport.resolver)
 	if err != nil {
 		return nil, err
 	}
 
-	smartAddressBook := decorateSelfIgnoreAddressBook(addressBook, peerID)
+	smartAddressBook := decorateSelfIgnoreAddressBook(transport.addressBook, transport.peerID)
 	handshakeSubscription := newHandshakeSubscription()
 
-	stopCh := make(chan struct{}, 2)
+	joinCh := make(chan struct{})
+	handshakeQueue := newHandshakeQueue()
 
-	acceptedCh, handshakeDataCh := startClient(url, peerMultiaddr, smartAddressBook, handshakeSubscription, stopCh)
-	return &signal{
+	ctx, stopClient := context.WithCancel(context.Background())
+	clientDoneCh := startClient(ctx, endpoints, transport.signalConfiguration, smartAddressBook,
+		handshakeSubscription, handshakeQueue, joinCh, transport.metrics, transport.events)
+	s := &signal{
 		transport:             transport,
-		peerID:                peerID,
-		peerMultiaddr:         peerMultiaddr,
-		signalMultiaddr:       signalMultiaddr,
-		acceptedCh:            acceptedCh,
+		peerID:                transport.peerID,
+		endpoints:             endpoints,
 		handshakeSubscription: handshakeSubscription,
-		handshakeDataCh:       handshakeDataCh,
-		stopCh:                stopCh,
-		webRTCConfiguration:   webRTCConfiguration,
-		multiplexer:           multiplexer,
-	}, nil
+		handshakeQueue:        handshakeQueue,
+		compactPeers:          map[peer.ID]bool{},
+		trackHandoffs:         map[*webrtc.PeerConnection]*trackHandoff{},
+		joinCh:                joinCh,
+		stopClient:            stopClient,
+		clientDoneCh:          clientDoneCh,
+		closedCh:              make(chan struct{}),
+		webRTCConfiguration:   transport.webRTCConfiguration,
+		peerConnectionPool:    transport.peerConnectionPool,
+		dataChannel:           transport.dataChannel,
+		multiplexer:           transport.multiplexer,
+
+		sessionDescriptionHook: transport.sessionDescriptionHook,
+		iceRecoveryWindow:      transport.iceRecoveryWindow,
+		idleTimeout:            transport.idleTimeout,
+		keepalive:              transport.keepalive,
+		metrics:                transport.metrics,
+		events:                 transport.events,
+	}
+
+	go s.handleRenegotiations()
+
+	if !transport.signalConfiguration.JoinOnListen {
+		s.join()
+	}
+	return s, nil
+}
+
+func (s *signal) join() {
+	s.joinOnce.Do(func() {
+		close(s.joinCh)
+	})
+}
+
+// listen joins the star and passes incoming offers to accept. Until then they are dropped.
+func (s *signal) listen() {
+	s.handshakeSubscription.listen()
+	s.join()
 }
 
 func createSignalURL(addr ma.Multiaddr, configuration SignalConfiguration) (string, error) {
-	websocketAddr := addr.Decapsulate(protocolMultiaddr)
+	return StarAddr{Signal: addr}.SignalURL(configuration.URLPath)
+}
 
-	var buf strings.Builder
-	buf.WriteString(readProtocolForSignalURL(websocketAddr))
+func createPeerMultiaddr(signalMultiaddr ma.Multiaddr, peerID peer.ID) ma.Multiaddr {
+	return StarAddr{Signal: signalMultiaddr, Peer: peerID}.Multiaddr()
+}
+
+func (s *signal) dial(ctx context.Context, remotePeerID peer.ID) (transport.CapableConn, error) {
+	// The star routes answers only to joined multiaddrs, so a dial-only node joins on its first dial.
+	s.join()
 
-	_, hostPort, err := manet.DialArgs(websocketAddr)
+	options := dialOptionsFromContext(ctx)
+	dataChannelInit := s.dataChannel.Init
+	if options.dataChannelInit != nil {
+		dataChannelInit = *options.dataChannelInit
+	}
+	err := validateSessionDataChannelInit(&dataChannelInit)
 	if err != nil {
-		return "", err
+		return nil, wrapOpError("dial", remotePeerID, "", err)
 	}
-	buf.WriteString(hostPort)
-	buf.WriteString(configuration.URLPath)
-	return buf.String(), nil
-}
 
-func createPeerMultiaddr(signalMultiaddr ma.Multiaddr, peerID peer.ID) (ma.Multiaddr, error) {
-	ipfsMultiaddr, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s", ipfsProtocolName, peerID.String()))
+	peerConnection, err := s.newPeerConnection(options.webRTCConfiguration(s.webRTCConfiguration),
+		!options.overridesWebRTCConfiguration())
 	if err != nil {
-		logger.Fatal(err)
+		return nil, wrapOpError("dial", remotePeerID, "", err)
 	}
-	return signalMultiaddr.Encapsulate(ipfsMultiaddr), nil
-}
 
-func readProtocolForSignalURL(maddr ma.Multiaddr) string {
-	if _, err := maddr.ValueForProtocol(wssProtocolCode); err == nil {
-		return "wss://"
+	err = addTracks(peerConnection, options.tracks)
+	if err != nil {
+		s.closePeerConnection(peerConnection)
+		return nil, wrapOpError("dial", remotePeerID, "", err)
 	}
-	return "ws://"
-}
 
-func (s *signal) dial(ctx context.Context, remotePeerID peer.ID) (transport.CapableConn, error) {
-	peerConnection, err := webrtcapi.NewPeerConnection(s.webRTCConfiguration)
+	intentID := createRandomIntentID()
+	s.events.emit(EvtHandshakeStarted{Peer: remotePeerID, IntentID: intentID, Outbound: true})
+
+	handshakeCtx := ctx
+	if options.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		handshakeCtx, cancel = context.WithTimeout(ctx, options.handshakeTimeout)
+		defer cancel()
+	}
+
+	connection, err := s.dialPeerConnection(handshakeCtx, remotePeerID, intentID, peerConnection,
+		&dataChannelInit)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = ErrHandshakeTimeout
+	}
 	if err != nil {
+		err = wrapOpError("dial", remotePeerID, intentID, err)
+		s.events.emit(EvtHandshakeFailed{Peer: remotePeerID, IntentID: intentID, Outbound: true, Err: err})
+		s.forgetSignalEncodings(remotePeerID)
+		s.closePeerConnection(peerConnection)
 		return nil, err
 	}
+	s.events.emit(EvtHandshakeSucceeded{Peer: remotePeerID, IntentID: intentID, Outbound: true})
+	return connection, nil
+}
 
+func (s *signal) dialPeerConnection(ctx context.Context, remotePeerID peer.ID, intentID string,
+	peerConnection *webrtc.PeerConnection, dataChannelInit *webrtc.DataChannelInit) (transport.CapableConn, error) {
 	offerDescription, err := peerConnection.CreateOffer(nil)
 	if err != nil {
 		return nil, err
@@ -132,41 +254,113 @@ func (s *signal) dial(ctx context.Context, remotePeerID peer.ID) (transport.Capa
 		return nil, err
 	}
 
-	dstMultiaddr, err := ma.NewMultiaddr(fmt.Sprintf("/%s/%s", ipfsProtocolName, remotePeerID.String()))
+	offerDescription, err = s.prepareLocalDescription(remotePeerID, offerDescription)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.endpoints.wait(ctx, s.closedCh)
 	if err != nil {
 		return nil, err
 	}
+
 	offer := handshakeData{
-		IntentID:     createRandomIntentID(),
-		SrcMultiaddr: s.peerMultiaddr.String(),
-		DstMultiaddr: s.signalMultiaddr.Encapsulate(dstMultiaddr).String(),
+		IntentID:     intentID,
+		SrcMultiaddr: endpoint.peerMultiaddr.String(),
+		DstMultiaddr: createPeerMultiaddr(endpoint.signalMultiaddr, remotePeerID).String(),
 		Signal:       offerDescription,
+		Features:     localFeatures,
+	}
+	err = s.compactSignal(remotePeerID, &offer)
+	if err != nil {
+		return nil, err
 	}
+
 	answer, err := s.doHandshake(ctx, offer)
 	if err != nil {
 		return nil, err
 	}
+	s.rememberSignalEncodings(remotePeerID, answer)
+
+	answerDescription, err := s.transformSessionDescription(remotePeerID, RemoteSessionDescription, answer.Signal)
+	if err != nil {
+		return nil, err
+	}
 
-	err = peerConnection.SetRemoteDescription(answer.Signal)
+	err = peerConnection.SetRemoteDescription(answerDescription)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.openConnection(ctx, offer.DstMultiaddr, peerConnection, false)
+	dataChannel, err := peerConnection.CreateDataChannel(s.dataChannel.label(), dataChannelInit)
+	if err != nil {
+		return nil, err
+	}
+	return s.openConnection(ctx, offer, answer.Features, peerConnection, dataChannel, false)
 }
 
 func (s *signal) accept() (transport.CapableConn, error) {
-	offer, ok := <-s.handshakeSubscription.unsubscribed()
-	if !ok {
-		return nil, errors.New("subscription channel has been closed")
+	for {
+		var offer handshakeData
+		select {
+		case offer = <-s.handshakeSubscription.unsubscribed():
+		case <-s.closedCh:
+			return nil, newOpError("accept", "", ErrTransportClosed, nil)
+		}
+
+		connection, err := s.acceptOffer(offer)
+		if err != nil {
+			logger.Errorf("Can't accept handshake offer (intentID: %s): %v", offer.IntentID, err)
+			incrementMetric(s.metrics, MetricConnectionsRejected)
+			continue
+		}
+		incrementMetric(s.metrics, MetricConnectionsAccepted)
+		return connection, nil
+	}
+}
+
+func (s *signal) acceptOffer(offer handshakeData) (transport.CapableConn, error) {
+	remotePeerID, err := extractPeerID(offer.SrcMultiaddr)
+	if err != nil {
+		return nil, wrapOpError("accept", "", offer.IntentID, err)
+	}
+
+	peerConnection, err := s.newPeerConnection(s.webRTCConfiguration, true)
+	if err != nil {
+		return nil, wrapOpError("accept", remotePeerID, offer.IntentID, err)
+	}
+
+	s.events.emit(EvtHandshakeStarted{Peer: remotePeerID, IntentID: offer.IntentID})
+
+	connection, err := s.acceptPeerConnection(remotePeerID, offer, peerConnection)
+	if err != nil {
+		err = wrapOpError("accept", remotePeerID, offer.IntentID, err)
+		s.events.emit(EvtHandshakeFailed{Peer: remotePeerID, IntentID: offer.IntentID, Err: err})
+		s.forgetSignalEncodings(remotePeerID)
+		s.closePeerConnection(peerConnection)
+		return nil, err
 	}
+	s.events.emit(EvtHandshakeSucceeded{Peer: remotePeerID, IntentID: offer.IntentID})
+	return connection, nil
+}
+
+func (s *signal) acceptPeerConnection(remotePeerID peer.ID, offer handshakeData,
+	peerConnection *webrtc.PeerConnection) (conn transport.CapableConn, err error) {
+	answered := false
+	defer func() {
+		if err != nil && !answered {
+			s.rejectOffer(offer, err)
+		}
+	}()
+
+	s.rememberSignalEncodings(remotePeerID, offer)
 
-	peerConnection, err := webrtcapi.NewPeerConnection(s.webRTCConfiguration)
+	offerDescription, err := s.transformSessionDescription(remotePeerID, RemoteSessionDescription, offer.Signal)
 	if err != nil {
 		return nil, err
 	}
 
-	err = peerConnection.SetRemoteDescription(offer.Signal)
+	err = peerConnection.SetRemoteDescription(offerDescription)
 	if err != nil {
 		return nil, err
 	}
@@ -181,74 +375,217 @@ func (s *signal) accept() (transport.CapableConn, error) {
 		return nil, err
 	}
 
+	answerDescription, err = s.prepareLocalDescription(remotePeerID, answerDescription)
+	if err != nil {
+		return nil, err
+	}
+
 	answer := handshakeData{
 		IntentID:     offer.IntentID,
 		SrcMultiaddr: offer.SrcMultiaddr,
-		DstMultiaddr: s.peerMultiaddr.String(),
+		DstMultiaddr: offer.DstMultiaddr,
 		Signal:       answerDescription,
 		Answer:       true,
+		Features:     localFeatures,
 	}
-	s.answerHandshake(answer)
-	return s.openConnection(context.Background(), offer.SrcMultiaddr, peerConnection, true)
+	err = s.compactSignal(remotePeerID, &answer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.answerHandshake(answer)
+	if err != nil {
+		return nil, err
+	}
+	answered = true
+	return s.openConnection(context.Background(), offer, offer.Features, peerConnection, nil, true)
 }
 
-func (s *signal) openConnection(ctx context.Context, destination string, peerConnection *webrtc.PeerConnection, isServer bool) (transport.CapableConn, error) {
-	dstMultiaddr, err := ma.NewMultiaddr(destination)
+// rejectOffer lets the dialing peer fail instead of waiting for the answer timeout.
+func (s *signal) rejectOffer(offer handshakeData, reason error) {
+	answer := handshakeData{
+		IntentID:     offer.IntentID,
+		SrcMultiaddr: offer.SrcMultiaddr,
+		DstMultiaddr: offer.DstMultiaddr,
+		Signal:       webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer}, // pion can't parse an unknown type
+		Answer:       true,
+		Err:          reason.Error(),
+	}
+	err := s.answerHandshake(answer)
+	if err != nil {
+		logger.Debugf("Can't reject handshake offer (intentID: %s): %v", offer.IntentID, err)
+	}
+}
+
+// prepareLocalDescription advertises the receive buffer and passes the local description to the hook.
+func (s *signal) prepareLocalDescription(remotePeerID peer.ID,
+	description webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	description.SDP = setMaxMessageSize(description.SDP, s.dataChannel.receiveBufferSize())
+	return s.transformSessionDescription(remotePeerID, LocalSessionDescription, description)
+}
+
+// openConnection creates the connection established by the offer. The dialing peer waits until its data channel
+// is open, the accepting peer gets the channel announced by the remote peer through the connection.
+func (s *signal) openConnection(ctx context.Context, offer handshakeData, remoteFeatures []string,
+	peerConnection *webrtc.PeerConnection, dataChannel *webrtc.DataChannel, isServer bool) (transport.CapableConn, error) {
+	source, destination := offer.SrcMultiaddr, offer.DstMultiaddr
+	if isServer {
+		source, destination = destination, source
+	}
+
+	srcMultiaddr, err := ma.NewMultiaddr(source)
 	if err != nil {
 		return nil, err
 	}
 
-	value, err := dstMultiaddr.ValueForProtocol(ma.P_P2P)
+	dstMultiaddr, err := ma.NewMultiaddr(destination)
 	if err != nil {
 		return nil, err
 	}
 
-	remotePeerID, err := peer.IDB58Decode(value)
+	remotePeerID, err := readPeerID(dstMultiaddr)
 	if err != nil {
 		return nil, err
 	}
 
 	var detachedDataChannel datachannel.ReadWriteCloser
+	var sctpTransport *webrtc.SCTPTransport
 	if !isServer {
-		channel, err := peerConnection.CreateDataChannel("data", nil)
-		if err != nil {
-			return nil, err
-		}
-
-		detachedCh := detachDataChannel(channel)
+		detachedCh := detachDataChannel(dataChannel)
+		iceFailedCh := watchICEFailure(peerConnection)
 		select {
 		case detached := <-detachedCh:
 			if detached.err != nil {
 				return nil, detached.err
 			}
 			detachedDataChannel = detached.dataChannel
+			sctpTransport = detached.sctpTransport
+		case <-iceFailedCh:
+			return nil, ErrICEFailed
 		case <-ctx.Done():
-			return nil, errors.New("detaching data channel cancelled")
+			return nil, ctx.Err()
 		}
 	}
 
-	return newConnection(connectionConfiguration{
+	streamConfiguration := s.dataChannel.streamConfiguration(peerConnection.RemoteDescription())
+	streamConfiguration.keepalive = containsString(remoteFeatures, featureKeepalive)
+
+	connection := newConnection(connectionConfiguration{
 		remotePeerID:        remotePeerID,
 		remotePeerMultiaddr: dstMultiaddr,
 
 		localPeerID:        s.peerID,
-		localPeerMultiaddr: s.peerMultiaddr,
+		localPeerMultiaddr: srcMultiaddr,
 
 		transport:   s.transport,
 		multiplexer: s.multiplexer,
 		isServer:    isServer,
-	}, peerConnection, detachedDataChannel), nil
+
+		intentID:       offer.IntentID,
+		remoteFeatures: remoteFeatures,
+
+		dataChannelLabel: s.dataChannel.label(),
+
+		stream: streamConfiguration,
+
+		iceRecoveryWindow: s.iceRecoveryWindow,
+		idleTimeout:       s.idleTimeout,
+		keepalive:         s.keepalive,
+		metrics:           s.metrics,
+		events:            s.events,
+
+		unregisterConnectionFunc: s.unregisterConnection,
+		dataChannelHandlerFunc:   s.transport.dataChannelHandler,
+		renegotiateFunc:          s.renegotiate,
+	}, peerConnection, detachedDataChannel)
+	connection.observeSCTPTransport(sctpTransport)
+	trackHandoff := s.unregisterTrackHandoff(peerConnection)
+	err = s.transport.registerConnection(connection)
+	if err != nil {
+		connection.discard(err) // the caller closes the peer connection
+		return nil, err
+	}
+	if trackHandoff != nil {
+		trackHandoff.ready(connection)
+	}
+	return connection, nil
 }
 
-func (s *signal) close() error {
-	return s.stopClient()
+// unregisterConnection forgets the closed connection and the signal encodings of its peer.
+func (s *signal) unregisterConnection(c *connection) {
+	s.forgetSignalEncodings(c.RemotePeer())
+	s.transport.unregisterConnection(c)
 }
 
-func (s *signal) stopClient() error {
-	s.stopCh <- struct{}{}
+// close stops accepting and dialing, disconnects from the signal server and waits for the client to exit.
+func (s *signal) close() error {
+	s.closeOnce.Do(func() {
+		close(s.closedCh)
+		s.handshakeSubscription.close()
+		s.stopClient()
+	})
+	<-s.clientDoneCh
 	return nil
 }
 
+func extractPeerID(addr string) (peer.ID, error) {
+	peerMultiaddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return "", err
+	}
+	return readPeerID(peerMultiaddr)
+}
+
+func readPeerID(peerMultiaddr ma.Multiaddr) (peer.ID, error) {
+	starAddr, err := SplitStarAddr(peerMultiaddr)
+	if err != nil {
+		return "", err
+	} else if starAddr.Peer == "" {
+		return "", fmt.Errorf("%w: peer ID missing: %s", ErrInvalidStarAddr, peerMultiaddr)
+	}
+	return starAddr.Peer, nil
+}
+
+// newPeerConnection takes a prewarmed peer connection from the pool if it's allowed, or creates a new one.
+func (s *signal) newPeerConnection(configuration webrtc.Configuration, pooled bool) (*webrtc.PeerConnection, error) {
+	var peerConnection *webrtc.PeerConnection
+	if pooled && s.peerConnectionPool != nil {
+		peerConnection = s.peerConnectionPool.get()
+	}
+	if peerConnection == nil {
+		var err error
+		peerConnection, err = webrtcapi.NewPeerConnection(configuration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	incrementMetric(s.metrics, MetricPeerConnectionsActive)
+	s.registerTrackHandoff(peerConnection)
+	return peerConnection, nil
+}
+
+func (s *signal) closePeerConnection(peerConnection *webrtc.PeerConnection) {
+	decrementMetric(s.metrics, MetricPeerConnectionsActive)
+	s.unregisterTrackHandoff(peerConnection)
+	err := peerConnection.Close()
+	if err != nil {
+		logger.Warningf("Can't close peer connection: %v", err)
+	}
+}
+
+func watchICEFailure(peerConnection *webrtc.PeerConnection) <-chan struct{} {
+	iceFailedCh := make(chan struct{})
+	var once sync.Once
+	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateFailed {
+			once.Do(func() {
+				close(iceFailedCh)
+			})
+		}
+	})
+	return iceFailedCh
+}
+
 func createRandomIntentID() string {
 	return createRandomID("signal")
 }
@@ -1,86 +1,245 @@
 package star
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gorilla/websocket"
-	"github.com/libp2p/go-libp2p-core/transport"
 	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/sync/errgroup"
 	"time"
 )
 
-func startClient(url string, peerMultiaddr ma.Multiaddr, addressBook addressBook,
-	handshakeSubscription *handshakeSubscription, stopCh <-chan struct{}) (<-chan transport.CapableConn,
-	chan<- handshakeData) {
-	logger.Debugf("Use signal server: %s", url)
+const reconnectDelay = 3 * time.Second
 
-	acceptedCh := make(chan transport.CapableConn)
-	handshakeDataCh := make(chan handshakeData)
+// signalClient keeps a session with the signal server, reconnecting until its context is cancelled. Every session
+// runs its reader, pinger and sender in one errgroup, so a failure of any of them tears down the whole session.
+type signalClient struct {
+	endpoints     *starEndpoints
+	configuration SignalConfiguration
+	dialer        *websocket.Dialer
 
-	internalStopCh := make(chan struct{})
-	threadsRunning := false
+	addressBook           addressBook
+	handshakeSubscription *handshakeSubscription
+	handshakeQueue        *handshakeQueue
+	joinCh                <-chan struct{}
 
-	stopSessionThreads := func() {
-		logger.Debugf("Stop active session threads")
+	metrics MetricsSink
+	events  *eventEmitters
+}
+
+// startClient runs the signal client until ctx is cancelled. The returned channel is closed once all client
+// goroutines have exited.
+func startClient(ctx context.Context, endpoints *starEndpoints, configuration SignalConfiguration,
+	addressBook addressBook, handshakeSubscription *handshakeSubscription, handshakeQueue *handshakeQueue,
+	joinCh <-chan struct{}, metrics MetricsSink, events *eventEmitters) <-chan struct{} {
+	logger.Debugf("Use signal server: %s", endpoints.addr)
+
+	c := &signalClient{
+		endpoints:     endpoints,
+		configuration: configuration,
+		dialer:        newWebsocketDialer(configuration),
+
+		addressBook:           addressBook,
+		handshakeSubscription: handshakeSubscription,
+		handshakeQueue:        handshakeQueue,
+		joinCh:                joinCh,
 
-		internalStopCh <- struct{}{}
-		internalStopCh <- struct{}{}
+		metrics: metrics,
+		events:  events,
 	}
 
+	doneCh := make(chan struct{})
 	go func() {
-		var connection *websocket.Conn
-		var sp *sessionProperties
-		var err error
-
-		for {
-			if stopSignalReceived(stopCh) {
-				logger.Debugf("Stop signal received. Closing")
-				stopSessionThreads()
-				close(handshakeDataCh)
+		defer close(doneCh)
+		c.run(ctx)
+	}()
+	return doneCh
+}
+
+func (c *signalClient) run(ctx context.Context) {
+	connectedBefore := false
+	for {
+		var connection signalConnection
+		endpoint, err := c.endpoints.next(ctx)
+		if err == nil {
+			connection, err = openConnection(ctx, c.dialer, endpoint.url, c.configuration)
+		}
+		if ctx.Err() != nil {
+			logger.Debugf("Stop signal received. Closing")
+			return
+		} else if err != nil {
+			logger.Errorf("Can't establish connection: %v", err)
+			select {
+			case <-ctx.Done():
 				return
+			case <-time.After(reconnectDelay):
 			}
+			continue
+		}
+
+		logger.Debugf("Connection to signal server established")
+		incrementMetric(c.metrics, MetricSignalConnects)
+		if connectedBefore {
+			incrementMetric(c.metrics, MetricSignalReconnects)
+		}
+		connectedBefore = true
+
+		err = c.runSession(ctx, connection, endpoint)
+		if ctx.Err() != nil {
+			logger.Debugf("Stop signal received. Closing")
+			return
+		}
+		logger.Errorf("Signal session closed: %v", err)
+	}
+}
+
+func (c *signalClient) runSession(ctx context.Context, connection signalConnection, endpoint starEndpoint) error {
+	sp, err := openSession(connection, c.configuration.readLimit(), endpoint.peerMultiaddr, c.joinCh)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	c.events.emit(EvtSignalConnected{SignalMultiaddr: endpoint.signalMultiaddr})
+
+	group, sessionCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		<-sessionCtx.Done()
+		if ctx.Err() != nil {
+			disconnect(connection)
+			return nil
+		}
+		return connection.Close()
+	})
+	group.Go(func() error {
+		return c.readMessages(connection, sp)
+	})
+	group.Go(func() error {
+		return c.sendPings(sessionCtx, connection, sp)
+	})
+	group.Go(func() error {
+		return c.sendMessages(sessionCtx, connection, sp, endpoint.peerMultiaddr)
+	})
+	err = group.Wait()
 
-			if !isConnectionHealthy(connection) {
-				if threadsRunning {
-					stopSessionThreads()
-					threadsRunning = false
-				}
-
-				connection, err = openConnection(url)
-				if err != nil {
-					logger.Errorf("Can't establish connection: %v", err)
-					time.Sleep(3 * time.Second)
-					continue
-				}
-				logger.Debugf("Connection to signal server established")
-
-				sp, err = openSession(connection, peerMultiaddr, handshakeDataCh, internalStopCh)
-				if err != nil {
-					logger.Errorf("Can't open session: %v", err)
-					connection = nil
-					continue
-				}
-				threadsRunning = true
+	if ctx.Err() != nil {
+		c.events.emit(EvtSignalDisconnected{SignalMultiaddr: endpoint.signalMultiaddr})
+		return ctx.Err()
+	}
+	c.events.emit(EvtSignalDisconnected{SignalMultiaddr: endpoint.signalMultiaddr, Err: err})
+	return err
+}
+
+func (c *signalClient) readMessages(connection signalConnection, sp *sessionProperties) error {
+	for {
+		message, err := readMessage(connection)
+		if err != nil {
+			logger.Debugf("%s: Can't read message: %v", sp.SID, err)
+			return err
+		}
+
+		logger.Debugf("%s: Received message: %s", sp.SID, message)
+		err = processMessage(c.addressBook, c.handshakeSubscription, message, c.metrics, c.events)
+		if err != nil {
+			logger.Warningf("%s: Can't process message: %v", sp.SID, err)
+		}
+	}
+}
+
+func (c *signalClient) sendPings(ctx context.Context, connection signalConnection, sp *sessionProperties) error {
+	pingInterval := time.Duration(sp.PingIntervalMillis * int64(time.Millisecond))
+	pingTimeout := time.Duration(sp.PingTimeoutMillis * int64(time.Millisecond))
+
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debugf("%s: Session closed. Stop ping ticker", sp.SID)
+			return nil
+		case <-pingTicker.C:
+			logger.Debugf("%s: Send ping message", sp.SID)
+			err := connection.SetReadDeadline(time.Now().Add(pingTimeout))
+			if err != nil {
+				logger.Errorf("%s: Can't set connection read deadline: %v", sp.SID, err)
+				continue
 			}
 
-			message, err := readMessage(connection)
+			err = sendMessage(connection, "ping", nil) // Application layer ping?
 			if err != nil {
-				logger.Errorf("%s: Can't read message: %v", sp.SID, err)
-				connection = nil
+				logger.Errorf("%s: Can't send ping message: %v", sp.SID, err)
 				continue
 			}
-			logger.Debugf("%s: Received message: %s", sp.SID, message)
-			err = processMessage(addressBook, handshakeSubscription, message)
+
+			err = connection.WriteControl(websocket.PingMessage, []byte("ping"), time.Time{})
 			if err != nil {
-				logger.Warningf("%s: Can't process message: %v", sp.SID, err)
+				logger.Errorf("%s: Can't send ping message: %v", sp.SID, err)
 				continue
 			}
 		}
-	}()
-	return acceptedCh, handshakeDataCh
+	}
+}
+
+// sendMessages flushes the handshake queue, which holds messages queued while there was no session, and
+// delivers new ones until the session is closed.
+func (c *signalClient) sendMessages(ctx context.Context, connection signalConnection, sp *sessionProperties,
+	peerMultiaddr ma.Multiaddr) error {
+	joinCh := c.joinCh
+	if sp.Joined {
+		joinCh = nil
+	}
+
+	sendJoin := func() error {
+		logger.Debugf("%s: Join peer network (peerID: %s)", sp.SID, peerMultiaddr.String())
+		joinCh = nil
+		return sendMessage(connection, "ss-join", peerMultiaddr.String())
+	}
+	// A dial joins right before queueing its offer, so a pending join has to go out before the offer.
+	flushHandshakes := func() error {
+		select {
+		case <-joinCh:
+			err := sendJoin()
+			if err != nil {
+				return err
+			}
+		default:
+		}
+		return c.handshakeQueue.flush(func(data handshakeData) error {
+			logger.Debugf("%s: Send handshake message (intentID: %s)", sp.SID, data.IntentID)
+			return sendMessage(connection, "ss-handshake", data)
+		})
+	}
+
+	err := flushHandshakes()
+	if err != nil {
+		logger.Errorf("%s: Can't send handshake message: %v", sp.SID, err)
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debugf("%s: Session closed. Stop handshake sender", sp.SID)
+			return nil
+		case <-joinCh:
+			err := sendJoin()
+			if err != nil {
+				return err
+			}
+		case <-c.handshakeQueue.ready():
+			err := flushHandshakes()
+			if err != nil {
+				logger.Errorf("%s: Can't send handshake message: %v", sp.SID, err)
+				return err
+			}
+		}
+	}
 }
 
-func openSession(connection *websocket.Conn, peerMultiaddr ma.Multiaddr,
-	handshakeDataCh <-chan handshakeData, stopCh <-chan struct{}) (*sessionProperties, error) {
+// openSession reads the session properties, upgrades the transport if possible and joins the peer network
+// if it has been requested already.
+func openSession(connection signalConnection, readLimit int64, peerMultiaddr ma.Multiaddr,
+	joinCh <-chan struct{}) (*sessionProperties, error) {
 	message, err := readMessage(connection)
 	if err != nil {
 		return nil, err
@@ -92,11 +251,16 @@ func openSession(connection *websocket.Conn, peerMultiaddr ma.Multiaddr,
 		return nil, err
 	}
 
-	pingInterval := time.Duration(sp.PingIntervalMillis * int64(time.Millisecond))
-	pingTimeout := time.Duration(sp.PingTimeoutMillis * int64(time.Millisecond))
-	logger.Debugf("%s: Ping interval: %v, Ping timeout: %v", sp.SID, pingInterval, pingTimeout)
+	if upgrader, ok := connection.(*pollingConnection); ok {
+		err = upgrader.upgrade(sp.Upgrades)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Debugf("%s: Ping interval: %dms, Ping timeout: %dms", sp.SID, sp.PingIntervalMillis, sp.PingTimeoutMillis)
 
-	connection.SetReadLimit(maxMessageSize)
+	connection.SetReadLimit(readLimit)
 	connection.SetPongHandler(func(string) error {
 		logger.Debugf("%s: Pong message received", sp.SID)
 		return connection.SetReadDeadline(time.Time{})
@@ -107,79 +271,40 @@ func openSession(connection *websocket.Conn, peerMultiaddr ma.Multiaddr,
 		return nil, err
 	}
 
-	go func() {
-		pingTicker := time.NewTicker(pingInterval)
-		for {
-			select {
-			case <-stopCh:
-				logger.Debugf("%s: Stop signal received. Close ping ticker", sp.SID)
-				pingTicker.Stop()
-				return
-			case <-pingTicker.C:
-				logger.Debugf("%s: Send ping message", sp.SID)
-				err := connection.SetReadDeadline(time.Now().Add(pingTimeout))
-				if err != nil {
-					logger.Errorf("%s: Can't set connection read deadline: %v", sp.SID, err)
-					continue
-				}
-
-				err = sendMessage(connection, "ping", nil) // Application layer ping?
-				if err != nil {
-					logger.Errorf("%s: Can't send ping message: %v", sp.SID, err)
-					continue
-				}
-
-				err = connection.WriteControl(websocket.PingMessage, []byte("ping"), time.Time{})
-				if err != nil {
-					logger.Errorf("%s: Can't send ping message: %v", sp.SID, err)
-					continue
-				}
-			}
+	// Join before any offer is sent, otherwise the star has nowhere to route answers to.
+	select {
+	case <-joinCh:
+		logger.Debugf("%s: Join peer network (peerID: %s)", sp.SID, peerMultiaddr.String())
+		err = sendMessage(connection, "ss-join", peerMultiaddr.String())
+		if err != nil {
+			return nil, err
 		}
-	}()
-
-	logger.Debugf("%s: Join peer network (peerID: %s)", sp.SID, peerMultiaddr.String())
-	err = sendMessage(connection, "ss-join", peerMultiaddr.String())
-	if err != nil {
-		return nil, err
+		sp.Joined = true
+	default:
 	}
-
-	go func() {
-		for {
-			select {
-			case <-stopCh:
-				logger.Debugf("%s: Stop signal received. Close handshake offer sender", sp.SID)
-				return
-			case offer := <-handshakeDataCh:
-				logger.Debugf("%s: Send handshake message", sp.SID)
-				err = sendMessage(connection, "ss-handshake", offer)
-				if err != nil {
-					logger.Errorf("%s: Can't send handshake offer: %v", sp.SID, err)
-					continue
-				}
-			}
-		}
-	}()
-
 	return &sp, nil
 }
 
-func stopSignalReceived(stopCh <-chan struct{}) bool {
-	select {
-	case <-stopCh:
-		return true
-	default:
-		return false
-	}
-}
+func openConnection(ctx context.Context, dialer *websocket.Dialer, url string,
+	configuration SignalConfiguration) (signalConnection, error) {
+	logger.Debugf("Open new connection: %s (transport: %s)", url, configuration.Transport)
 
-func isConnectionHealthy(connection *websocket.Conn) bool {
-	return connection != nil
-}
+	if configuration.Transport == PollingSignalTransport {
+		connection, err := openPollingConnection(ctx, dialer, url, configuration)
+		if err != nil {
+			return nil, err
+		}
+		return connection, nil
+	}
 
-func openConnection(url string) (*websocket.Conn, error) {
-	logger.Debugf("Open new connection: %s", url)
+	header, err := createRequestHeader(configuration)
+	if err != nil {
+		return nil, err
+	}
 
-	connection, _, err := websocket.DefaultDialer.Dial(url, nil)
-	return connection, err
+	connection, _, err := dialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	return connection, nil
 }
@@ -0,0 +1,219 @@
+package star
+
+import (
+	"context"
+	"errors"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartClientJoinsOnlyWhenRequested(t *testing.T) {
+	server := newTestSignalServer()
+	defer server.Close()
+
+	peerMultiaddr, err := ma.NewMultiaddr(testPeerMultiaddr)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	joinCh := make(chan struct{})
+	handshakeQueue := newHandshakeQueue()
+	doneCh := startClient(ctx, testStarEndpoints(server.signalURL(), peerMultiaddr), SignalConfiguration{},
+		discardAddressBook{}, newHandshakeSubscription(), handshakeQueue, joinCh, noopMetricsSink{}, nil)
+
+	// when
+	go handshakeQueue.send(ctx, nil, handshakeData{IntentID: "intent"}, time.Now().Add(time.Minute))
+	offerMessage := receiveSignalMessage(t, server)
+
+	close(joinCh)
+	joinMessage := receiveSignalMessage(t, server)
+
+	// then
+	assert.Contains(t, offerMessage, `42["ss-handshake",{"intentId":"intent"`)
+	assert.Equal(t, `42["ss-join","`+testPeerMultiaddr+`"]`, joinMessage)
+
+	cancel()
+	<-doneCh
+}
+
+func TestSignalClientLifecycleDoesNotLeakGoroutines(t *testing.T) {
+	for _, configuration := range []SignalConfiguration{
+		{},
+		{Transport: PollingSignalTransport},
+		{Transport: PollingSignalTransport, UpgradeTransport: true},
+	} {
+		server := newTestSignalServer()
+
+		peerMultiaddr, err := ma.NewMultiaddr(testPeerMultiaddr)
+		require.NoError(t, err)
+		joinCh := make(chan struct{})
+		close(joinCh)
+		metrics := newRecordingMetricsSink()
+
+		// when
+		ctx, cancel := context.WithCancel(context.Background())
+		doneCh := startClient(ctx, testStarEndpoints(server.signalURL(), peerMultiaddr), configuration,
+			discardAddressBook{}, newHandshakeSubscription(), newHandshakeQueue(), joinCh, metrics, nil)
+		require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+
+		server.dropSessions()
+		require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+
+		cancel()
+
+		// then
+		select {
+		case <-doneCh:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "signal client not stopped", "transport: %s", configuration.Transport)
+		}
+		assert.Equal(t, disconnectMessage, receiveSignalMessage(t, server))
+		assert.Equal(t, float64(1), metrics.value(MetricSignalReconnects))
+		assertNoSignalClientGoroutines(t)
+
+		server.Close()
+	}
+}
+
+func TestSignalClientStopsWhileReconnecting(t *testing.T) {
+	server := newTestSignalServer()
+	signalURL := server.signalURL()
+	server.Close()
+
+	peerMultiaddr, err := ma.NewMultiaddr(testPeerMultiaddr)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := startClient(ctx, testStarEndpoints(signalURL, peerMultiaddr), SignalConfiguration{},
+		discardAddressBook{}, newHandshakeSubscription(), newHandshakeQueue(), make(chan struct{}),
+		noopMetricsSink{}, nil)
+
+	// when
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	// then
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		require.FailNow(t, "signal client not stopped")
+	}
+	assertNoSignalClientGoroutines(t)
+}
+
+func TestSignalClientFlushesQueuedHandshakesAfterJoin(t *testing.T) {
+	server := newTestSignalServer()
+	defer server.Close()
+
+	peerMultiaddr, err := ma.NewMultiaddr(testPeerMultiaddr)
+	require.NoError(t, err)
+
+	handshakeQueue := newHandshakeQueue()
+	sentCh := make(chan error, 2)
+	for _, intentID := range []string{"first", "second"} {
+		go func(intentID string) {
+			sentCh <- handshakeQueue.send(context.Background(), nil, handshakeData{IntentID: intentID},
+				time.Now().Add(time.Minute))
+		}(intentID)
+		time.Sleep(10 * time.Millisecond) // keep the order
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	joinCh := make(chan struct{})
+	close(joinCh)
+
+	// when
+	doneCh := startClient(ctx, testStarEndpoints(server.signalURL(), peerMultiaddr), SignalConfiguration{},
+		discardAddressBook{}, newHandshakeSubscription(), handshakeQueue, joinCh, noopMetricsSink{}, nil)
+
+	// then
+	assert.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+	assert.Contains(t, receiveSignalMessage(t, server), `"intentId":"first"`)
+	assert.Contains(t, receiveSignalMessage(t, server), `"intentId":"second"`)
+	assert.NoError(t, <-sentCh)
+	assert.NoError(t, <-sentCh)
+
+	cancel()
+	<-doneCh
+}
+
+func TestHandshakeQueueExpiresUndeliveredMessage(t *testing.T) {
+	handshakeQueue := newHandshakeQueue()
+
+	// when
+	startTime := time.Now()
+	err := handshakeQueue.send(context.Background(), nil, handshakeData{IntentID: "intent"},
+		startTime.Add(100*time.Millisecond))
+
+	// then
+	assert.Equal(t, ErrSignalUnavailable, err)
+	assert.True(t, time.Since(startTime) < time.Second)
+	assert.NoError(t, handshakeQueue.flush(func(handshakeData) error {
+		return errors.New("expired message flushed")
+	}))
+}
+
+func TestSignalClientReadsMessagesUpToConfiguredSize(t *testing.T) {
+	server := newTestSignalServer()
+	defer server.Close()
+
+	peerMultiaddr, err := ma.NewMultiaddr(testPeerMultiaddr)
+	require.NoError(t, err)
+	joinCh := make(chan struct{})
+	close(joinCh)
+	metrics := newRecordingMetricsSink()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doneCh := startClient(ctx, testStarEndpoints(server.signalURL(), peerMultiaddr),
+		SignalConfiguration{MaxMessageSize: 4 * maxMessageSize}, discardAddressBook{}, newHandshakeSubscription(),
+		newHandshakeQueue(), joinCh, metrics, nil)
+	require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+
+	// when
+	padding := strings.Repeat(" ", 2*maxMessageSize)
+	server.broadcast(`42["ws-peer",` + padding + `"` + testPeerMultiaddr + `"]`)
+
+	// then
+	assert.Eventually(t, func() bool {
+		return metrics.value(MetricPeerAnnouncements) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, metrics.value(MetricSignalReconnects))
+
+	cancel()
+	<-doneCh
+}
+
+func testStarEndpoints(url string, peerMultiaddr ma.Multiaddr) *starEndpoints {
+	return staticStarEndpoints(starEndpoint{url: url, signalMultiaddr: peerMultiaddr, peerMultiaddr: peerMultiaddr})
+}
+
+func assertNoSignalClientGoroutines(t *testing.T) {
+	var leaked []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		leaked = signalClientGoroutines()
+		if len(leaked) == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Empty(t, leaked, "leaked goroutines")
+}
+
+func signalClientGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	var found []string
+	for _, goroutine := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(goroutine, "/signal_client.go:") || strings.Contains(goroutine, "/signal_polling.go:") {
+			found = append(found, goroutine)
+		}
+	}
+	return found
+}
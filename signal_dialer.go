@@ -0,0 +1,50 @@
+package star
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+)
+
+type HeaderProvider func() (http.Header, error)
+
+func newWebsocketDialer(configuration SignalConfiguration) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if configuration.Dialer != nil {
+		dialer = *configuration.Dialer
+	}
+
+	if configuration.NetDialer != nil {
+		dialer.NetDial = nil
+		dialer.NetDialContext = configuration.NetDialer.DialContext
+	}
+	if configuration.TLSConfig != nil {
+		dialer.TLSClientConfig = configuration.TLSConfig
+	}
+	if configuration.Proxy != nil {
+		dialer.Proxy = configuration.Proxy
+	}
+	if configuration.HandshakeTimeout > 0 {
+		dialer.HandshakeTimeout = configuration.HandshakeTimeout
+	}
+	return &dialer
+}
+
+func createRequestHeader(configuration SignalConfiguration) (http.Header, error) {
+	header := http.Header{}
+	for key, values := range configuration.Header {
+		header[key] = append([]string(nil), values...)
+	}
+
+	if configuration.HeaderProvider == nil {
+		return header, nil
+	}
+
+	provided, err := configuration.HeaderProvider()
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range provided {
+		header[key] = append([]string(nil), values...)
+	}
+	return header, nil
+}
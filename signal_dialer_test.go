@@ -0,0 +1,78 @@
+package star
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOpenConnectionSendsHeadersOverTLS(t *testing.T) {
+	headersCh := make(chan http.Header, 2)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headersCh <- r.Header
+		connection, err := new(websocket.Upgrader).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		connection.Close()
+	}))
+	defer server.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	var tokenNumber int32
+	configuration := SignalConfiguration{
+		Header: http.Header{
+			"Cookie":        []string{"session=abc"},
+			"Authorization": []string{"Bearer static"},
+		},
+		HeaderProvider: func() (http.Header, error) {
+			n := atomic.AddInt32(&tokenNumber, 1)
+			return http.Header{
+				"Authorization": []string{fmt.Sprintf("Bearer rotated-%d", n)},
+			}, nil
+		},
+		TLSConfig: &tls.Config{RootCAs: rootCAs},
+	}
+	dialer := newWebsocketDialer(configuration)
+	url := "wss://" + strings.TrimPrefix(server.URL, "https://")
+
+	// when
+	for i := 0; i < 2; i++ {
+		connection, err := openConnection(context.Background(), dialer, url, configuration)
+		require.NoError(t, err)
+		connection.Close()
+	}
+
+	// then
+	first := <-headersCh
+	assert.Equal(t, "session=abc", first.Get("Cookie"))
+	assert.Equal(t, "Bearer rotated-1", first.Get("Authorization"))
+
+	second := <-headersCh
+	assert.Equal(t, "Bearer rotated-2", second.Get("Authorization"))
+}
+
+func TestOpenConnectionRejectsUnknownCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	configuration := SignalConfiguration{}
+	url := "wss://" + strings.TrimPrefix(server.URL, "https://")
+
+	// when
+	_, err := openConnection(context.Background(), newWebsocketDialer(configuration), url, configuration)
+
+	// then
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package star
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// handshakeQueue holds outgoing ss-handshake messages until a signal session delivers them. It outlives sessions,
+// so messages queued while the star is unreachable are flushed after reconnect and re-join.
+type handshakeQueue struct {
+	m     sync.Mutex
+	items []*queuedHandshake
+
+	readyCh chan struct{}
+}
+
+type queuedHandshake struct {
+	data     handshakeData
+	deadline time.Time
+
+	cancelled bool
+	resultCh  chan error
+}
+
+func newHandshakeQueue() *handshakeQueue {
+	return &handshakeQueue{
+		readyCh: make(chan struct{}, 1),
+	}
+}
+
+// send queues the message and waits until it's delivered. ErrSignalUnavailable is returned as soon as
+// the deadline passes before delivery.
+func (q *handshakeQueue) send(ctx context.Context, closedCh <-chan struct{}, data handshakeData,
+	deadline time.Time) error {
+	item := &queuedHandshake{
+		data:     data,
+		deadline: deadline,
+		resultCh: make(chan error, 1),
+	}
+
+	q.m.Lock()
+	q.items = append(q.items, item)
+	q.m.Unlock()
+	q.notify()
+
+	expired := time.NewTimer(time.Until(deadline))
+	defer expired.Stop()
+
+	var err error
+	select {
+	case err = <-item.resultCh:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-closedCh:
+		err = ErrTransportClosed
+	case <-expired.C:
+		err = ErrSignalUnavailable
+	}
+
+	if !q.cancel(item) && <-item.resultCh == nil {
+		return nil // delivered meanwhile
+	}
+	return err
+}
+
+func (q *handshakeQueue) cancel(item *queuedHandshake) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	for i, queued := range q.items {
+		if queued == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	item.cancelled = true
+	return false
+}
+
+func (q *handshakeQueue) notify() {
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (q *handshakeQueue) ready() <-chan struct{} {
+	return q.readyCh
+}
+
+// flush delivers queued messages in order. On a send error the message is put back and the error is returned,
+// so the next session retries it.
+func (q *handshakeQueue) flush(send func(handshakeData) error) error {
+	for {
+		q.m.Lock()
+		if len(q.items) == 0 {
+			q.m.Unlock()
+			return nil
+		}
+		item := q.items[0]
+		q.items = q.items[1:]
+		q.m.Unlock()
+
+		if time.Now().After(item.deadline) {
+			item.resultCh <- ErrSignalUnavailable
+			continue
+		}
+
+		err := send(item.data)
+		if err != nil {
+			q.requeue(item)
+			return err
+		}
+		item.resultCh <- nil
+	}
+}
+
+func (q *handshakeQueue) requeue(item *queuedHandshake) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if item.cancelled {
+		item.resultCh <- ErrSignalUnavailable
+		return
+	}
+	q.items = append([]*queuedHandshake{item}, q.items...)
+}
@@ -10,7 +10,21 @@ import (
 	"time"
 )
 
-const handshakeAnswerTimeout = 5 * time.Minute
+const (
+	handshakeAnswerTimeout    = 5 * time.Minute
+	signalAvailabilityTimeout = 30 * time.Second
+
+	renegotiationBacklog = 16
+)
+
+// Optional protocol extensions advertised in Features. Peers without them, e.g. js-libp2p-webrtc-star, would
+// mistake their messages for new offers or stream data.
+const (
+	featureRenegotiation = "renegotiation"
+	featureKeepalive     = "keepalive"
+)
+
+var localFeatures = []string{featureRenegotiation, featureKeepalive}
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
@@ -22,6 +36,20 @@ type handshakeData struct {
 	DstMultiaddr string                    `json:"dstMultiaddr"`
 	Signal       webrtc.SessionDescription `json:"signal"`
 	Answer       bool                      `json:"answer,omitempty"`
+	Err          string                    `json:"err,omitempty"`
+
+	// SignalEncodings lists the encodings of Signal.SDP the sender can expand. CompactSignal replaces
+	// Signal.SDP if the receiver advertised support for it.
+	SignalEncodings []string `json:"signalEncodings,omitempty"`
+	CompactSignal   string   `json:"compactSignal,omitempty"`
+
+	// Features lists the optional protocol extensions the sender of an offer or an answer supports.
+	Features []string `json:"features,omitempty"`
+
+	// Renegotiate is the intent ID of the handshake which established the connection to update. An offer
+	// without Signal asks the dialing peer to renegotiate and receive media of MediaKinds.
+	Renegotiate string   `json:"renegotiate,omitempty"`
+	MediaKinds  []string `json:"mediaKinds,omitempty"`
 }
 
 func (hd *handshakeData) String() string {
@@ -37,39 +65,68 @@ func (s *signal) doHandshake(ctx context.Context, offer handshakeData) (handshak
 	subscription := s.handshakeSubscription.subscribe(offer.IntentID)
 
 	logger.Debugf("Send handshake offer (intentID: %s)", offer.IntentID)
-	s.handshakeDataCh <- offer
+	err := s.handshakeQueue.send(ctx, s.closedCh, offer, time.Now().Add(signalAvailabilityTimeout))
+	if err != nil {
+		logger.Debugf("Can't deliver handshake offer (intentID: %s): %v", offer.IntentID, err)
+		s.handshakeSubscription.cancel(offer.IntentID)
+		return handshakeData{}, err
+	}
+	incrementMetric(s.metrics, MetricOffersSent)
+	startTime := time.Now()
 
 	timeout := time.After(handshakeAnswerTimeout)
 	select {
 	case answer := <-subscription:
+		if answer.Err != "" && offer.Renegotiate != "" {
+			logger.Debugf("Renegotiation rejected (intentID: %s): %s", offer.IntentID, answer.Err)
+			return handshakeData{}, &OpError{Op: "renegotiate", IntentID: offer.IntentID,
+				Kind: ErrRenegotiationFailed, Err: errors.New(answer.Err)}
+		} else if answer.Err != "" && answer.Answer {
+			logger.Debugf("Handshake rejected by remote peer (intentID: %s): %s", offer.IntentID, answer.Err)
+			return handshakeData{}, &OpError{Op: "dial", IntentID: offer.IntentID, Kind: ErrHandshakeRejected,
+				Err: errors.New(answer.Err)}
+		} else if answer.Err != "" {
+			logger.Debugf("Handshake rejected by signal server (intentID: %s): %s", offer.IntentID, answer.Err)
+			return handshakeData{}, &OpError{Op: "dial", IntentID: offer.IntentID, Kind: ErrPeerNotPresent,
+				Err: errors.New(answer.Err)}
+		}
+
 		logger.Debugf("Handshake answer received (intentID: %s)", offer.IntentID)
+		observeDuration(s.metrics, MetricHandshakeDuration, startTime)
 		return answer, nil
 	case <-ctx.Done():
 		logger.Debugf("Cancel handshake (intentID: %s)", offer.IntentID)
 		s.handshakeSubscription.cancel(offer.IntentID)
-		return handshakeData{}, errors.New("handshake canceled")
+		return handshakeData{}, ctx.Err()
 	case <-timeout:
 		logger.Debugf("Handshake timeout (intentID: %s)", offer.IntentID)
+		incrementMetric(s.metrics, MetricHandshakeTimeouts)
 		s.handshakeSubscription.cancel(offer.IntentID)
-		return handshakeData{}, errors.New("handshake answer timeout")
+		return handshakeData{}, ErrHandshakeTimeout
 	}
 }
 
-func (s *signal) answerHandshake(answer handshakeData) {
-	s.handshakeDataCh <- answer
+func (s *signal) answerHandshake(answer handshakeData) error {
+	return s.handshakeQueue.send(context.Background(), s.closedCh, answer, time.Now().Add(signalAvailabilityTimeout))
 }
 
 type handshakeSubscription struct {
 	m sync.Mutex
 
-	subscribers map[string]chan handshakeData
-	sink        chan handshakeData
+	subscribers    map[string]chan handshakeData
+	sink           chan handshakeData
+	renegotiations chan handshakeData
+	listening      bool
+	closedCh       chan struct{}
+	closeOnce      sync.Once
 }
 
 func newHandshakeSubscription() *handshakeSubscription {
 	return &handshakeSubscription{
-		subscribers: map[string]chan handshakeData{},
-		sink:        make(chan handshakeData),
+		subscribers:    map[string]chan handshakeData{},
+		sink:           make(chan handshakeData),
+		renegotiations: make(chan handshakeData, renegotiationBacklog),
+		closedCh:       make(chan struct{}),
 	}
 }
 
@@ -77,33 +134,59 @@ func (hs *handshakeSubscription) emit(data handshakeData) {
 	logger.Debugf("Emit handshake data (intentID: %s)", data.IntentID)
 
 	hs.m.Lock()
-	defer hs.m.Unlock()
-
 	if c, ok := hs.subscribers[data.IntentID]; ok {
 		c <- data
 		delete(hs.subscribers, data.IntentID)
 		close(c)
+		hs.m.Unlock()
 		return
 	}
-
-	if !data.Answer {
-		hs.sink <- data
+	listening := hs.listening
+	hs.m.Unlock()
+
+	// Offers wait for accept without holding hs.m, so subscribe and cancel aren't blocked meanwhile.
+	if data.Err != "" {
+		logger.Debugf("Received error to probably cancelled handshake (intentID: %s): %s", data.IntentID, data.Err)
+	} else if !data.Answer && data.Renegotiate != "" {
+		select {
+		case hs.renegotiations <- data:
+		default:
+			logger.Warningf("Drop renegotiation, too many pending (intentID: %s)", data.IntentID)
+		}
+	} else if !data.Answer && !listening {
+		logger.Debugf("Drop handshake offer, not listening (intentID: %s)", data.IntentID)
+	} else if !data.Answer {
+		select {
+		case hs.sink <- data:
+		case <-hs.closedCh:
+			logger.Debugf("Drop handshake offer, no longer accepting (intentID: %s)", data.IntentID)
+		}
 	} else {
 		logger.Debugf("Received answer to probably cancelled handshake (intentID: %s)", data.IntentID)
 	}
 }
 
+func (hs *handshakeSubscription) listen() {
+	hs.m.Lock()
+	defer hs.m.Unlock()
+	hs.listening = true
+}
+
 func (hs *handshakeSubscription) unsubscribed() <-chan handshakeData {
 	return hs.sink
 }
 
+func (hs *handshakeSubscription) renegotiationRequests() <-chan handshakeData {
+	return hs.renegotiations
+}
+
 func (hs *handshakeSubscription) subscribe(intentID string) <-chan handshakeData {
 	logger.Debugf("Subscribe to the specific handshake (intentID: %s)", intentID)
 
 	hs.m.Lock()
 	defer hs.m.Unlock()
 
-	hs.subscribers[intentID] = make(chan handshakeData)
+	hs.subscribers[intentID] = make(chan handshakeData, 1)
 	return hs.subscribers[intentID]
 }
 
@@ -113,7 +196,15 @@ func (hs *handshakeSubscription) cancel(intentID string) {
 	hs.m.Lock()
 	defer hs.m.Unlock()
 
-	c := hs.subscribers[intentID]
-	delete(hs.subscribers, intentID)
-	close(c)
+	// emit may have delivered the answer and closed the channel already
+	if c, ok := hs.subscribers[intentID]; ok {
+		delete(hs.subscribers, intentID)
+		close(c)
+	}
+}
+
+func (hs *handshakeSubscription) close() {
+	hs.closeOnce.Do(func() {
+		close(hs.closedCh)
+	})
 }
@@ -0,0 +1,78 @@
+package star
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDoHandshakeCancelledWhileAnswerArrives(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		s := &signal{
+			handshakeSubscription: newHandshakeSubscription(),
+			handshakeQueue:        newHandshakeQueue(),
+			closedCh:              make(chan struct{}),
+			metrics:               noopMetricsSink{},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// the answer is emitted and ctx is cancelled before doHandshake selects, so it may take either branch
+		go func() {
+			<-s.handshakeQueue.ready()
+			_ = s.handshakeQueue.flush(func(offer handshakeData) error {
+				s.handshakeSubscription.emit(handshakeData{IntentID: offer.IntentID, Answer: true})
+				cancel()
+				return nil
+			})
+		}()
+
+		// when
+		var err error
+		require.NotPanics(t, func() {
+			_, err = s.doHandshake(ctx, handshakeData{IntentID: "intent"})
+		})
+
+		// then
+		if err != nil {
+			assert.Equal(t, context.Canceled, err)
+		}
+		s.handshakeSubscription.m.Lock()
+		assert.Empty(t, s.handshakeSubscription.subscribers)
+		s.handshakeSubscription.m.Unlock()
+		cancel()
+	}
+}
+
+func TestHandshakeSubscriptionDropsOffersWhenNotListening(t *testing.T) {
+	hs := newHandshakeSubscription()
+	emittedCh := make(chan struct{})
+
+	// when
+	go func() {
+		hs.emit(handshakeData{IntentID: "offer"})
+		close(emittedCh)
+	}()
+
+	// then
+	select {
+	case <-emittedCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "offer not dropped")
+	}
+}
+
+func TestHandshakeSubscriptionWaitsForAcceptWithoutBlockingSubscribers(t *testing.T) {
+	hs := newHandshakeSubscription()
+	hs.listen()
+	go hs.emit(handshakeData{IntentID: "offer"})
+
+	// when
+	subscription := hs.subscribe("dial")
+	hs.emit(handshakeData{IntentID: "dial", Answer: true})
+
+	// then
+	assert.Equal(t, "dial", (<-subscription).IntentID)
+	assert.Equal(t, "offer", (<-hs.unsubscribed()).IntentID)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
@@ -11,11 +12,16 @@ import (
 	"time"
 )
 
-const wsPeerAliveTTL = 60 * time.Second
+const (
+	wsPeerAliveTTL    = 60 * time.Second
+	disconnectMessage = "41"
+	disconnectTimeout = time.Second
+)
 
 var mSendMessage sync.Mutex
 
-func processMessage(addressBook addressBook, handshakeSubscription *handshakeSubscription, message []byte) error {
+func processMessage(addressBook addressBook, handshakeSubscription *handshakeSubscription, message []byte,
+	metrics MetricsSink, events *eventEmitters) error {
 	if bytes.Index(message, []byte(`["ws-peer",`)) == 0 {
 		var m []string
 		err := json.Unmarshal(message, &m)
@@ -24,57 +30,54 @@ func processMessage(addressBook addressBook, handshakeSubscription *handshakeSub
 		} else if len(m) < 2 {
 			return errors.New("missing peer information")
 		}
-		return processWsPeerMessage(addressBook, m[1])
+		incrementMetric(metrics, MetricPeerAnnouncements)
+		return processWsPeerMessage(addressBook, m[1], events)
 	} else if bytes.Index(message, []byte(`["ws-handshake",`)) == 0 {
-		return processWsHandshakeMessage(handshakeSubscription, message[len(`["ws-handshake",`):len(message)-1])
+		return processWsHandshakeMessage(handshakeSubscription, message[len(`["ws-handshake",`):len(message)-1], metrics)
 	}
 	return errors.New("tried to process unknown message")
 }
 
-func processWsPeerMessage(addressBook addressBook, message string) error {
+func processWsPeerMessage(addressBook addressBook, message string, events *eventEmitters) error {
 	peerID, signalMultiaddr, err := extractPeerDestination(message)
 	if err != nil {
 		return err
 	}
 
 	addressBook.AddAddr(peerID, signalMultiaddr, wsPeerAliveTTL)
+	events.emit(EvtPeerAnnounced{Peer: peerID, SignalMultiaddr: signalMultiaddr})
 	return nil
 }
 
-func processWsHandshakeMessage(handshakeSubscription *handshakeSubscription, message []byte) error {
+func processWsHandshakeMessage(handshakeSubscription *handshakeSubscription, message []byte, metrics MetricsSink) error {
 	var answer handshakeData
 	err := json.Unmarshal(message, &answer)
 	if err != nil {
 		return err
 	}
-	handshakeSubscription.emit(answer)
-	return nil
-}
 
-func extractPeerDestination(peerAddr string) (peer.ID, ma.Multiaddr, error) {
-	peerMultiaddr, err := ma.NewMultiaddr(peerAddr)
+	err = answer.expandSignal()
 	if err != nil {
-		return "", nil, err
-	}
-
-	value, err := peerMultiaddr.ValueForProtocol(ma.P_IPFS)
-	if err != nil {
-		return "", nil, err
+		return err
 	}
-
-	peerID, err := peer.IDB58Decode(value)
-	if err != nil {
-		return "", nil, err
+	if !answer.Answer {
+		incrementMetric(metrics, MetricOffersReceived)
 	}
+	handshakeSubscription.emit(answer)
+	return nil
+}
 
-	ipfsMultiaddr, err := ma.NewMultiaddr("/ipfs/" + peerID.String())
+func extractPeerDestination(peerAddr string) (peer.ID, ma.Multiaddr, error) {
+	starAddr, err := ParseStarAddr(peerAddr)
 	if err != nil {
 		return "", nil, err
+	} else if starAddr.Peer == "" {
+		return "", nil, fmt.Errorf("%w: peer ID missing: %s", ErrInvalidStarAddr, peerAddr)
 	}
-	return peerID, peerMultiaddr.Decapsulate(ipfsMultiaddr), nil
+	return starAddr.Peer, starAddr.Signal, nil
 }
 
-func readMessage(connection *websocket.Conn) ([]byte, error) {
+func readMessage(connection signalConnection) ([]byte, error) {
 	_, message, err := connection.ReadMessage()
 	if err != nil {
 		return nil, err
@@ -87,7 +90,7 @@ func readMessage(connection *websocket.Conn) ([]byte, error) {
 	return message[i:], nil
 }
 
-func sendMessage(connection *websocket.Conn, messageType string, messageBody interface{}) error {
+func sendMessage(connection signalConnection, messageType string, messageBody interface{}) error {
 	var buffer bytes.Buffer
 	buffer.WriteString(messagePrefix)
 	buffer.WriteString(`["`)
@@ -110,7 +113,7 @@ func sendMessage(connection *websocket.Conn, messageType string, messageBody int
 	return connection.WriteMessage(websocket.TextMessage, buffer.Bytes())
 }
 
-func readEmptyMessage(connection *websocket.Conn) error {
+func readEmptyMessage(connection signalConnection) error {
 	_, message, err := connection.ReadMessage()
 	if err != nil {
 		return err
@@ -122,3 +125,24 @@ func readEmptyMessage(connection *websocket.Conn) error {
 	}
 	return nil
 }
+
+// disconnect leaves the Socket.IO namespace and closes the connection, so the star can drop the peer immediately.
+func disconnect(connection signalConnection) {
+	mSendMessage.Lock()
+	err := connection.WriteMessage(websocket.TextMessage, []byte(disconnectMessage))
+	mSendMessage.Unlock()
+	if err != nil {
+		logger.Debugf("Can't send disconnect message: %v", err)
+	}
+
+	err = connection.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(disconnectTimeout))
+	if err != nil {
+		logger.Debugf("Can't send close message: %v", err)
+	}
+
+	err = connection.Close()
+	if err != nil {
+		logger.Debugf("Can't close signal connection: %v", err)
+	}
+}
@@ -0,0 +1,633 @@
+package star
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SignalTransport selects the Engine.IO transport used to talk to the signal server.
+type SignalTransport int
+
+const (
+	WebSocketSignalTransport SignalTransport = iota
+	PollingSignalTransport
+)
+
+func (t SignalTransport) String() string {
+	switch t {
+	case WebSocketSignalTransport:
+		return "websocket"
+	case PollingSignalTransport:
+		return "polling"
+	}
+	return fmt.Sprintf("SignalTransport(%d)", int(t))
+}
+
+const (
+	engineIOOpenPacket    = '0'
+	engineIOClosePacket   = '1'
+	engineIOPingPacket    = '2'
+	engineIOPongPacket    = '3'
+	engineIOUpgradePacket = '5'
+	engineIONoopPacket    = '6'
+
+	pollingUpgradeTimeout = 10 * time.Second
+
+	// minPollingPayloadLimit bounds response bodies before the read limit is set. A payload may batch several
+	// base64 encoded packets, so the body can be longer than the read limit.
+	minPollingPayloadLimit = 1 << 20
+)
+
+var (
+	errPollingReadTimeout = errors.New("polling read timeout")
+	errPollingReadLimit   = errors.New("polling read limit exceeded")
+	errPollingClosed      = errors.New("polling connection closed")
+)
+
+// signalConnection is the subset of *websocket.Conn used by the signal client.
+type signalConnection interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+var (
+	_ signalConnection = new(websocket.Conn)
+	_ signalConnection = new(pollingConnection)
+)
+
+// pollingConnection implements the Engine.IO v3 long-polling transport. Packets received by a background poller
+// are queued and returned one by one, so the connection can be used exactly like a WebSocket one. After a
+// successful upgrade all operations are delegated to the WebSocket connection.
+type pollingConnection struct {
+	client        *http.Client
+	dialer        *websocket.Dialer
+	configuration SignalConfiguration
+	pollingURL    *url.URL
+	websocketURL  *url.URL
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	m            sync.Mutex
+	sid          string
+	pending      [][]byte
+	pollErr      error
+	polling      bool
+	readLimit    int64
+	readDeadline time.Time
+	pongHandler  func(string) error
+	upgraded     *websocket.Conn
+	notifyCh     chan struct{}
+
+	mWrite        sync.Mutex
+	stopPollingCh chan struct{}
+	pollerDoneCh  chan struct{}
+	stopOnce      sync.Once
+	closeOnce     sync.Once
+}
+
+func openPollingConnection(ctx context.Context, dialer *websocket.Dialer, rawURL string,
+	configuration SignalConfiguration) (*pollingConnection, error) {
+	websocketURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	setEngineIOTransport(websocketURL, WebSocketSignalTransport)
+
+	pollingURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	setEngineIOTransport(pollingURL, PollingSignalTransport)
+
+	// The session outlives the dial context, only the handshake request is bound to it.
+	sessionCtx, cancel := context.WithCancel(context.Background())
+	c := &pollingConnection{
+		client:        newPollingHTTPClient(dialer),
+		dialer:        dialer,
+		configuration: configuration,
+		pollingURL:    pollingURL,
+		websocketURL:  websocketURL,
+		ctx:           sessionCtx,
+		cancel:        cancel,
+		polling:       true,
+		notifyCh:      make(chan struct{}, 1),
+		stopPollingCh: make(chan struct{}),
+		pollerDoneCh:  make(chan struct{}),
+	}
+
+	packets, err := c.poll(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if len(packets) == 0 || len(packets[0]) == 0 || packets[0][0] != engineIOOpenPacket {
+		cancel()
+		return nil, errors.New("open packet expected")
+	}
+
+	var sp sessionProperties
+	err = json.Unmarshal(packets[0][1:], &sp)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	c.sid = sp.SID
+	c.pending = packets
+	go c.pollLoop()
+	return c, nil
+}
+
+func newPollingHTTPClient(dialer *websocket.Dialer) *http.Client {
+	transport := &http.Transport{
+		Proxy:               dialer.Proxy,
+		TLSClientConfig:     dialer.TLSClientConfig,
+		DialContext:         dialer.NetDialContext,
+		TLSHandshakeTimeout: dialer.HandshakeTimeout,
+	}
+	return &http.Client{Transport: transport, Jar: dialer.Jar}
+}
+
+func setEngineIOTransport(u *url.URL, t SignalTransport) {
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+		if t == WebSocketSignalTransport {
+			u.Scheme = "ws"
+		}
+	case "wss", "https":
+		u.Scheme = "https"
+		if t == WebSocketSignalTransport {
+			u.Scheme = "wss"
+		}
+	}
+
+	query := u.Query()
+	query.Set("transport", t.String())
+	if t == PollingSignalTransport {
+		query.Set("b64", "1")
+	} else {
+		query.Del("b64")
+	}
+	u.RawQuery = query.Encode()
+}
+
+func (c *pollingConnection) requestURL(u *url.URL) string {
+	requestURL := *u
+	query := requestURL.Query()
+	query.Set("t", strconv.FormatInt(time.Now().UnixNano(), 36))
+	if c.sid != "" {
+		query.Set("sid", c.sid)
+	}
+	requestURL.RawQuery = query.Encode()
+	return requestURL.String()
+}
+
+func (c *pollingConnection) poll(ctx context.Context) ([][]byte, error) {
+	body, err := c.doRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEngineIOPayload(body)
+}
+
+func (c *pollingConnection) doRequest(ctx context.Context, method string, body []byte) ([]byte, error) {
+	header, err := createRequestHeader(c.configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	request, err := http.NewRequest(method, c.requestURL(c.pollingURL), reader)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	request.Header = header
+	if body != nil {
+		request.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	payloadLimit := c.payloadLimit()
+	responseBody, err := ioutil.ReadAll(io.LimitReader(response.Body, payloadLimit+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(responseBody)) > payloadLimit {
+		return nil, errPollingReadLimit
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected polling response (status: %d): %s", response.StatusCode,
+			strings.TrimSpace(string(responseBody)))
+	}
+	return responseBody, nil
+}
+
+func (c *pollingConnection) payloadLimit() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if limit := 4 * c.readLimit; limit > minPollingPayloadLimit {
+		return limit
+	}
+	return minPollingPayloadLimit
+}
+
+func (c *pollingConnection) pollLoop() {
+	defer close(c.pollerDoneCh)
+
+	for {
+		select {
+		case <-c.stopPollingCh:
+			c.finishPolling(nil)
+			return
+		default:
+		}
+
+		packets, err := c.poll(c.ctx)
+		if err != nil {
+			c.finishPolling(err)
+			return
+		}
+
+		c.m.Lock()
+		c.pending = append(c.pending, packets...)
+		c.m.Unlock()
+		c.notify()
+
+		for _, packet := range packets {
+			if len(packet) > 0 && packet[0] == engineIOClosePacket {
+				c.finishPolling(nil)
+				return
+			}
+		}
+	}
+}
+
+func (c *pollingConnection) finishPolling(err error) {
+	c.m.Lock()
+	c.polling = false
+	if c.pollErr == nil {
+		c.pollErr = err
+	}
+	c.m.Unlock()
+	c.notify()
+}
+
+func (c *pollingConnection) notify() {
+	select {
+	case c.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (c *pollingConnection) ReadMessage() (int, []byte, error) {
+	for {
+		packet, upgraded, err := c.nextPacket()
+		if err != nil {
+			return 0, nil, err
+		}
+		if upgraded != nil {
+			messageType, message, err := upgraded.ReadMessage()
+			if err == nil && len(message) == 1 && message[0] == engineIONoopPacket {
+				continue
+			}
+			return messageType, message, err
+		}
+
+		if len(packet) == 0 {
+			continue
+		}
+
+		switch packet[0] {
+		case engineIOPongPacket:
+			c.m.Lock()
+			pongHandler := c.pongHandler
+			c.m.Unlock()
+
+			if pongHandler != nil {
+				err = pongHandler(string(packet[1:]))
+				if err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		case engineIONoopPacket:
+			continue
+		case engineIOClosePacket:
+			return 0, nil, io.EOF
+		}
+
+		c.m.Lock()
+		readLimit := c.readLimit
+		c.m.Unlock()
+
+		if readLimit > 0 && int64(len(packet)) > readLimit {
+			return 0, nil, errPollingReadLimit
+		}
+		return websocket.TextMessage, packet, nil
+	}
+}
+
+func (c *pollingConnection) nextPacket() ([]byte, *websocket.Conn, error) {
+	for {
+		c.m.Lock()
+		if len(c.pending) > 0 {
+			packet := c.pending[0]
+			c.pending = c.pending[1:]
+			c.m.Unlock()
+			return packet, nil, nil
+		}
+		if !c.polling {
+			upgraded, pollErr := c.upgraded, c.pollErr
+			c.m.Unlock()
+
+			if upgraded != nil {
+				return nil, upgraded, nil
+			} else if pollErr != nil {
+				return nil, nil, pollErr
+			}
+			return nil, nil, errPollingClosed
+		}
+		deadline := c.readDeadline
+		c.m.Unlock()
+
+		if deadline.IsZero() {
+			<-c.notifyCh
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-c.notifyCh:
+			timer.Stop()
+		case <-timer.C:
+			return nil, nil, errPollingReadTimeout
+		}
+	}
+}
+
+func (c *pollingConnection) WriteMessage(messageType int, data []byte) error {
+	c.mWrite.Lock()
+	defer c.mWrite.Unlock()
+
+	if upgraded := c.upgradedConnection(); upgraded != nil {
+		return upgraded.WriteMessage(messageType, data)
+	}
+
+	_, err := c.doRequest(c.ctx, http.MethodPost, encodeEngineIOPayload([][]byte{data}))
+	return err
+}
+
+func (c *pollingConnection) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if upgraded := c.upgradedConnection(); upgraded != nil {
+		return upgraded.WriteControl(messageType, data, deadline)
+	}
+
+	if messageType != websocket.PingMessage {
+		return nil
+	}
+	return c.WriteMessage(websocket.TextMessage, append([]byte{engineIOPingPacket}, data...))
+}
+
+func (c *pollingConnection) SetReadLimit(limit int64) {
+	c.m.Lock()
+	c.readLimit = limit
+	upgraded := c.upgraded
+	c.m.Unlock()
+
+	if upgraded != nil {
+		upgraded.SetReadLimit(limit)
+	}
+}
+
+func (c *pollingConnection) SetReadDeadline(t time.Time) error {
+	c.m.Lock()
+	c.readDeadline = t
+	upgraded := c.upgraded
+	c.m.Unlock()
+	c.notify()
+
+	if upgraded != nil {
+		return upgraded.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *pollingConnection) SetPongHandler(h func(appData string) error) {
+	c.m.Lock()
+	c.pongHandler = h
+	upgraded := c.upgraded
+	c.m.Unlock()
+
+	if upgraded != nil {
+		upgraded.SetPongHandler(h)
+	}
+}
+
+func (c *pollingConnection) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		if upgraded := c.upgradedConnection(); upgraded != nil {
+			c.stopPolling()
+			c.cancel()
+			err = upgraded.Close()
+			return
+		}
+
+		c.stopPolling()
+		c.mWrite.Lock()
+		_, closeErr := c.doRequest(c.ctx, http.MethodPost, encodeEngineIOPayload([][]byte{{engineIOClosePacket}}))
+		c.mWrite.Unlock()
+		if closeErr != nil {
+			logger.Debugf("%s: Can't send close packet: %v", c.sid, closeErr)
+		}
+		c.cancel()
+	})
+	c.client.CloseIdleConnections()
+	return err
+}
+
+func (c *pollingConnection) stopPolling() {
+	c.stopOnce.Do(func() {
+		close(c.stopPollingCh)
+	})
+}
+
+func (c *pollingConnection) upgradedConnection() *websocket.Conn {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.upgraded
+}
+
+// upgrade switches the session to WebSocket if enabled and offered by the server. The probe is exchanged first,
+// then polling is paused (the server flushes the pending poll with a noop) and the upgrade packet is sent.
+// If the probe fails, the session stays on polling.
+func (c *pollingConnection) upgrade(upgrades []string) error {
+	if !c.configuration.UpgradeTransport || !containsString(upgrades, WebSocketSignalTransport.String()) {
+		return nil
+	}
+
+	header, err := createRequestHeader(c.configuration)
+	if err != nil {
+		return err
+	}
+
+	upgraded, _, err := c.dialer.Dial(c.requestURL(c.websocketURL), header)
+	if err != nil {
+		logger.Warningf("%s: Can't open WebSocket transport, stay on polling: %v", c.sid, err)
+		return nil
+	}
+
+	err = c.probe(upgraded)
+	if err != nil {
+		logger.Warningf("%s: Transport probe failed, stay on polling: %v", c.sid, err)
+		upgraded.Close()
+		return nil
+	}
+
+	c.mWrite.Lock()
+	defer c.mWrite.Unlock()
+
+	c.stopPolling()
+	select {
+	case <-c.pollerDoneCh:
+	case <-time.After(pollingUpgradeTimeout):
+		c.cancel()
+		<-c.pollerDoneCh
+	}
+
+	err = upgraded.WriteMessage(websocket.TextMessage, []byte{engineIOUpgradePacket})
+	if err != nil {
+		upgraded.Close()
+		return err
+	}
+
+	c.m.Lock()
+	c.upgraded = upgraded
+	c.pollErr = nil
+	if c.readLimit > 0 {
+		upgraded.SetReadLimit(c.readLimit)
+	}
+	if c.pongHandler != nil {
+		upgraded.SetPongHandler(c.pongHandler)
+	}
+	c.m.Unlock()
+	c.notify()
+
+	logger.Debugf("%s: Transport upgraded to %s", c.sid, WebSocketSignalTransport)
+	return nil
+}
+
+func (c *pollingConnection) probe(upgraded *websocket.Conn) error {
+	err := upgraded.WriteMessage(websocket.TextMessage, []byte{engineIOPingPacket, 'p', 'r', 'o', 'b', 'e'})
+	if err != nil {
+		return err
+	}
+
+	err = upgraded.SetReadDeadline(time.Now().Add(pollingUpgradeTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, message, err := upgraded.ReadMessage()
+	if err != nil {
+		return err
+	} else if string(message) != string(engineIOPongPacket)+"probe" {
+		return fmt.Errorf("unexpected probe response: %s", message)
+	}
+	return upgraded.SetReadDeadline(time.Time{})
+}
+
+// decodeEngineIOPayload decodes the Engine.IO v3 string payload ("<length>:<packet>..."). Lengths are counted
+// in UTF-16 code units.
+func decodeEngineIOPayload(payload []byte) ([][]byte, error) {
+	var packets [][]byte
+	for len(payload) > 0 {
+		i := bytes.IndexByte(payload, ':')
+		if i <= 0 {
+			return nil, errors.New("invalid payload length")
+		}
+
+		length, err := strconv.Atoi(string(payload[:i]))
+		if err != nil {
+			return nil, err
+		}
+		payload = payload[i+1:]
+
+		end := 0
+		for units := 0; units < length; {
+			if end >= len(payload) {
+				return nil, errors.New("payload truncated")
+			}
+
+			r, size := utf8.DecodeRune(payload[end:])
+			end += size
+			units += utf16RuneLen(r)
+		}
+
+		packets = append(packets, payload[:end])
+		payload = payload[end:]
+	}
+	return packets, nil
+}
+
+func encodeEngineIOPayload(packets [][]byte) []byte {
+	var buffer bytes.Buffer
+	for _, packet := range packets {
+		length := 0
+		for _, r := range string(packet) {
+			length += utf16RuneLen(r)
+		}
+
+		buffer.WriteString(strconv.Itoa(length))
+		buffer.WriteByte(':')
+		buffer.Write(packet)
+	}
+	return buffer.Bytes()
+}
+
+func utf16RuneLen(r rune) int {
+	if utf16.IsSurrogate(r) || r < 0x10000 {
+		return 1
+	}
+	return 2
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
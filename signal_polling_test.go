@@ -0,0 +1,126 @@
+package star
+
+import (
+	"context"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPeerMultiaddr = "/dns4/star.example.com/tcp/443/wss/p2p-webrtc-star/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSooo2d"
+
+func TestEncodeDecodeEngineIOPayload(t *testing.T) {
+	packets := [][]byte{[]byte("40"), []byte(`42["ws-peer","zażółć 😀"]`), []byte("6")}
+
+	// when
+	payload := encodeEngineIOPayload(packets)
+	decoded, err := decodeEngineIOPayload(payload)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, packets, decoded)
+	assert.Equal(t, "2:40", string(payload[:4]))
+}
+
+func TestDecodeEngineIOPayloadRejectsTruncatedPacket(t *testing.T) {
+	// when
+	_, err := decodeEngineIOPayload([]byte("10:42[]"))
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestPollingSignalTransport(t *testing.T) {
+	for _, upgrade := range []bool{false, true} {
+		server := newTestSignalServer()
+
+		configuration := SignalConfiguration{
+			Transport:        PollingSignalTransport,
+			UpgradeTransport: upgrade,
+		}
+		peerMultiaddr, err := ma.NewMultiaddr(testPeerMultiaddr)
+		require.NoError(t, err)
+
+		// when
+		connection, err := openConnection(context.Background(), newWebsocketDialer(configuration), server.signalURL(), configuration)
+		require.NoError(t, err)
+
+		joinCh := make(chan struct{})
+		close(joinCh)
+		sp, err := openSession(connection, maxMessageSize, peerMultiaddr, joinCh)
+		require.NoError(t, err)
+		joinMessage := receiveSignalMessage(t, server)
+
+		server.broadcast(`42["ws-peer","/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSooo2d"]`)
+		message, err := readMessage(connection)
+		require.NoError(t, err)
+
+		err = sendMessage(connection, "ss-handshake", handshakeData{IntentID: "intent"})
+		require.NoError(t, err)
+
+		// then
+		assert.Equal(t, "sid-1", sp.SID)
+		assert.Equal(t, []string{"websocket"}, sp.Upgrades)
+		assert.Equal(t, `42["ss-join","`+testPeerMultiaddr+`"]`, joinMessage)
+		assert.Equal(t, `["ws-peer","/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSooo2d"]`, string(message))
+		assert.Contains(t, receiveSignalMessage(t, server), `42["ss-handshake",{"intentId":"intent"`)
+		assert.Equal(t, upgrade, server.session().isUpgraded())
+
+		connection.Close()
+		server.Close()
+	}
+}
+
+func TestPollingConnectionReadDeadline(t *testing.T) {
+	server := newTestSignalServer()
+	defer server.Close()
+
+	configuration := SignalConfiguration{Transport: PollingSignalTransport}
+	connection, err := openConnection(context.Background(), newWebsocketDialer(configuration), server.signalURL(), configuration)
+	require.NoError(t, err)
+	defer connection.Close()
+
+	_, _, err = connection.ReadMessage() // open packet
+	require.NoError(t, err)
+	_, _, err = connection.ReadMessage() // connect packet
+	require.NoError(t, err)
+
+	// when
+	err = connection.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	require.NoError(t, err)
+	_, _, err = connection.ReadMessage()
+
+	// then
+	assert.Equal(t, errPollingReadTimeout, err)
+}
+
+func receiveSignalMessage(t *testing.T, server *testSignalServer) string {
+	select {
+	case message := <-server.receivedCh:
+		return message
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "signal message not received")
+		return ""
+	}
+}
+
+func TestPollingConnectionRejectsOversizedPayload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("0", 2*minPollingPayloadLimit)))
+	}))
+	defer server.Close()
+
+	configuration := SignalConfiguration{Transport: PollingSignalTransport}
+
+	// when
+	_, err := openConnection(context.Background(), newWebsocketDialer(configuration),
+		strings.Replace(server.URL, "http", "ws", 1), configuration)
+
+	// then
+	assert.Equal(t, errPollingReadLimit, err)
+}
@@ -0,0 +1,204 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pion/webrtc/v2"
+	"time"
+)
+
+// Renegotiation updates the session description of an established connection over the signal server. Only the
+// dialing peer creates offers, pion/webrtc v2 can't roll back colliding ones. The accepting peer asks it for
+// an offer naming the kinds of its new tracks, so that the offer has a media section for each of them.
+//
+// A failed renegotiation leaves the dialing peer with a pending offer, further renegotiations fail.
+
+const renegotiationTimeout = 30 * time.Second
+
+var (
+	errRenegotiationUnsupported = errors.New("remote peer doesn't support renegotiation")
+	errUnexpectedRenegotiation  = errors.New("unexpected renegotiation message")
+)
+
+// renegotiate announces a new local track of the given kind to the remote peer.
+func (s *signal) renegotiate(c *connection, kind webrtc.RTPCodecType) error {
+	ctx, cancel := context.WithTimeout(context.Background(), renegotiationTimeout)
+	defer cancel()
+
+	if c.configuration.isServer {
+		request := newRenegotiationData(c)
+		request.Signal = webrtc.SessionDescription{Type: webrtc.SDPTypeOffer} // pion can't parse an unknown type
+		request.MediaKinds = []string{kind.String()}
+		_, err := s.doHandshake(ctx, request)
+		return err
+	}
+	return s.offerRenegotiation(ctx, c, nil)
+}
+
+// offerRenegotiation sends an offer receiving media of the remote kinds, one at a time.
+func (s *signal) offerRenegotiation(ctx context.Context, c *connection, remoteKinds []webrtc.RTPCodecType) error {
+	c.mRenegotiation.Lock()
+	defer c.mRenegotiation.Unlock()
+
+	peerConnection, err := c.getPeerConnection()
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range remoteKinds {
+		_, err = peerConnection.AddTransceiverFromKind(kind, webrtc.RtpTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	offerDescription, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+
+	err = peerConnection.SetLocalDescription(offerDescription)
+	if err != nil {
+		return err
+	}
+
+	offerDescription, err = s.prepareLocalDescription(c.RemotePeer(), offerDescription)
+	if err != nil {
+		return err
+	}
+
+	offer := newRenegotiationData(c)
+	offer.Signal = offerDescription
+	err = s.compactSignal(c.RemotePeer(), &offer)
+	if err != nil {
+		return err
+	}
+
+	answer, err := s.doHandshake(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	answerDescription, err := s.transformSessionDescription(c.RemotePeer(), RemoteSessionDescription, answer.Signal)
+	if err != nil {
+		return err
+	}
+	return peerConnection.SetRemoteDescription(answerDescription)
+}
+
+func (s *signal) handleRenegotiations() {
+	for {
+		select {
+		case request := <-s.handshakeSubscription.renegotiationRequests():
+			// answering a request waits for the answer to an offer, which is read by the signal client
+			go s.handleRenegotiation(request)
+		case <-s.closedCh:
+			return
+		}
+	}
+}
+
+// handleRenegotiation answers an offer of the dialing peer, or a request of the accepting one once its offer
+// has been answered.
+func (s *signal) handleRenegotiation(request handshakeData) {
+	answer := newRenegotiationAnswer(request)
+	err := s.answerRenegotiation(request, &answer)
+	if err != nil {
+		logger.Warningf("Can't renegotiate connection (intentID: %s): %v", request.Renegotiate, err)
+		answer = newRenegotiationAnswer(request)
+		answer.Err = err.Error()
+	}
+
+	err = s.answerHandshake(answer)
+	if err != nil {
+		logger.Debugf("Can't answer renegotiation (intentID: %s): %v", request.IntentID, err)
+	}
+}
+
+func (s *signal) answerRenegotiation(request handshakeData, answer *handshakeData) error {
+	remotePeerID, err := extractPeerID(request.SrcMultiaddr)
+	if err != nil {
+		return err
+	}
+
+	c, ok := s.transport.lookupHandshakeConnection(remotePeerID, request.Renegotiate)
+	if !ok {
+		return ErrConnectionClosed
+	}
+
+	if request.Signal.SDP == "" {
+		if c.configuration.isServer {
+			return errUnexpectedRenegotiation
+		}
+
+		var remoteKinds []webrtc.RTPCodecType
+		for _, name := range request.MediaKinds {
+			kind := webrtc.NewRTPCodecType(name)
+			if kind == 0 {
+				return fmt.Errorf("%w: unknown media kind: %s", errUnexpectedRenegotiation, name)
+			}
+			remoteKinds = append(remoteKinds, kind)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), renegotiationTimeout)
+		defer cancel()
+		return s.offerRenegotiation(ctx, c, remoteKinds)
+	}
+
+	if !c.configuration.isServer {
+		return errUnexpectedRenegotiation
+	}
+
+	peerConnection, err := c.getPeerConnection()
+	if err != nil {
+		return err
+	}
+
+	offerDescription, err := s.transformSessionDescription(remotePeerID, RemoteSessionDescription, request.Signal)
+	if err != nil {
+		return err
+	}
+
+	err = peerConnection.SetRemoteDescription(offerDescription)
+	if err != nil {
+		return err
+	}
+
+	answerDescription, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+
+	err = peerConnection.SetLocalDescription(answerDescription)
+	if err != nil {
+		return err
+	}
+
+	answer.Signal, err = s.prepareLocalDescription(remotePeerID, answerDescription)
+	if err != nil {
+		return err
+	}
+	return s.compactSignal(remotePeerID, answer)
+}
+
+func newRenegotiationAnswer(request handshakeData) handshakeData {
+	return handshakeData{
+		IntentID:     request.IntentID,
+		SrcMultiaddr: request.SrcMultiaddr,
+		DstMultiaddr: request.DstMultiaddr,
+		Signal:       webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer},
+		Answer:       true,
+	}
+}
+
+func newRenegotiationData(c *connection) handshakeData {
+	return handshakeData{
+		IntentID:     createRandomIntentID(),
+		SrcMultiaddr: c.configuration.localPeerMultiaddr.String(),
+		DstMultiaddr: c.configuration.remotePeerMultiaddr.String(),
+		Renegotiate:  c.configuration.intentID,
+	}
+}
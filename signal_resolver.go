@@ -0,0 +1,262 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multiaddr-dns"
+	"sync"
+	"time"
+)
+
+const starProbeTimeout = 5 * time.Second
+
+var errNoStarServers = errors.New("no star servers found")
+
+// StarResolver resolves /dnsaddr star multiaddrs to the addresses of concrete star servers.
+// *madns.Resolver implements it.
+type StarResolver interface {
+	Resolve(ctx context.Context, maddr ma.Multiaddr) ([]ma.Multiaddr, error)
+}
+
+var _ StarResolver = madns.DefaultResolver
+
+// starEndpoint is the star server a signal client connects to and the address the node joins with.
+type starEndpoint struct {
+	url             string
+	signalMultiaddr ma.Multiaddr
+	peerMultiaddr   ma.Multiaddr
+}
+
+// starEndpoints keeps the endpoint of a signal. A concrete address has a single endpoint. A /dnsaddr address is
+// resolved again before every connection attempt and the star server with the lowest connect latency is picked.
+type starEndpoints struct {
+	addr          ma.Multiaddr
+	peerID        peer.ID
+	configuration SignalConfiguration
+	resolver      StarResolver
+
+	m          sync.Mutex
+	endpoint   starEndpoint
+	resolvedCh chan struct{}
+	once       sync.Once
+}
+
+func newStarEndpoints(addr ma.Multiaddr, peerID peer.ID, configuration SignalConfiguration,
+	resolver StarResolver) (*starEndpoints, error) {
+	e := &starEndpoints{
+		addr:          addr,
+		peerID:        peerID,
+		configuration: configuration,
+		resolver:      resolver,
+		resolvedCh:    make(chan struct{}),
+	}
+	if isDnsaddr(addr) {
+		return e, nil
+	}
+
+	endpoint, err := createStarEndpoint(addr, peerID, configuration)
+	if err != nil {
+		return nil, err
+	}
+	e.setEndpoint(endpoint)
+	return e, nil
+}
+
+// staticStarEndpoints always connects to the same endpoint.
+func staticStarEndpoints(endpoint starEndpoint) *starEndpoints {
+	e := &starEndpoints{
+		addr:       endpoint.signalMultiaddr,
+		resolvedCh: make(chan struct{}),
+	}
+	e.setEndpoint(endpoint)
+	return e
+}
+
+func createStarEndpoint(signalMultiaddr ma.Multiaddr, peerID peer.ID,
+	configuration SignalConfiguration) (starEndpoint, error) {
+	url, err := createSignalURL(signalMultiaddr, configuration)
+	if err != nil {
+		return starEndpoint{}, err
+	}
+	return starEndpoint{
+		url:             url,
+		signalMultiaddr: signalMultiaddr,
+		peerMultiaddr:   createPeerMultiaddr(signalMultiaddr, peerID),
+	}, nil
+}
+
+// current returns the endpoint of the last connection attempt, if there was any.
+func (e *starEndpoints) current() (starEndpoint, bool) {
+	select {
+	case <-e.resolvedCh:
+	default:
+		return starEndpoint{}, false
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.endpoint, true
+}
+
+// wait returns the current endpoint, waiting until the star address is resolved for the first time.
+func (e *starEndpoints) wait(ctx context.Context, closedCh <-chan struct{}) (starEndpoint, error) {
+	select {
+	case <-e.resolvedCh:
+	case <-ctx.Done():
+		return starEndpoint{}, ctx.Err()
+	case <-closedCh:
+		return starEndpoint{}, ErrTransportClosed
+	}
+
+	endpoint, _ := e.current()
+	return endpoint, nil
+}
+
+// next returns the endpoint for the next connection attempt. If the address can't be resolved again,
+// the previous endpoint is kept.
+func (e *starEndpoints) next(ctx context.Context) (starEndpoint, error) {
+	if !isDnsaddr(e.addr) {
+		endpoint, _ := e.current()
+		return endpoint, nil
+	}
+
+	endpoint, err := e.resolve(ctx)
+	if err == nil {
+		e.setEndpoint(endpoint)
+		return endpoint, nil
+	}
+
+	previous, ok := e.current()
+	if !ok {
+		return starEndpoint{}, err
+	}
+	logger.Warningf("Can't resolve %s, keep %s: %v", e.addr, previous.signalMultiaddr, err)
+	return previous, nil
+}
+
+func (e *starEndpoints) resolve(ctx context.Context) (starEndpoint, error) {
+	candidates, err := resolveStars(ctx, e.resolver, e.addr)
+	if err != nil {
+		return starEndpoint{}, err
+	}
+
+	selected, err := selectStar(ctx, candidates, e.configuration)
+	if err != nil {
+		return starEndpoint{}, err
+	}
+	return createStarEndpoint(selected, e.peerID, e.configuration)
+}
+
+// resolveStars returns the signal addresses of the concrete star servers behind a /dnsaddr address.
+func resolveStars(ctx context.Context, resolver StarResolver, addr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	resolved, err := resolver.Resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var stars []ma.Multiaddr
+	for _, resolvedAddr := range resolved {
+		starAddr, err := SplitStarAddr(resolvedAddr)
+		if err != nil || isDnsaddr(starAddr.Signal) {
+			logger.Debugf("Ignore resolved address: %s", resolvedAddr)
+			continue
+		}
+		stars = append(stars, starAddr.Signal)
+	}
+	if len(stars) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoStarServers, addr)
+	}
+	return stars, nil
+}
+
+func (e *starEndpoints) setEndpoint(endpoint starEndpoint) {
+	e.m.Lock()
+	e.endpoint = endpoint
+	e.m.Unlock()
+
+	e.once.Do(func() {
+		close(e.resolvedCh)
+	})
+}
+
+// selectStar probes all candidates concurrently and picks the one which completes the Engine.IO handshake first.
+func selectStar(ctx context.Context, candidates []ma.Multiaddr, configuration SignalConfiguration) (ma.Multiaddr,
+	error) {
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, starProbeTimeout)
+	defer cancel()
+
+	type probe struct {
+		addr    ma.Multiaddr
+		latency time.Duration
+		err     error
+	}
+
+	probeCh := make(chan probe, len(candidates))
+	for _, candidate := range candidates {
+		go func(candidate ma.Multiaddr) {
+			startTime := time.Now()
+			err := probeStar(ctx, candidate, configuration)
+			probeCh <- probe{addr: candidate, latency: time.Since(startTime), err: err}
+		}(candidate)
+	}
+
+	var lastErr error
+	for range candidates {
+		p := <-probeCh
+		if p.err != nil {
+			logger.Debugf("Star server unavailable: %s (%v)", p.addr, p.err)
+			lastErr = p.err
+			continue
+		}
+		logger.Debugf("Select star server: %s (latency: %v)", p.addr, p.latency)
+		return p.addr, nil
+	}
+	return nil, lastErr
+}
+
+// probeStar opens an Engine.IO session with the configured transport and closes it once the open packet is
+// received. A TCP connection alone doesn't show that the star serves Socket.IO on the signal URL.
+func probeStar(ctx context.Context, signalMultiaddr ma.Multiaddr, configuration SignalConfiguration) error {
+	url, err := createSignalURL(signalMultiaddr, configuration)
+	if err != nil {
+		return err
+	}
+
+	connection, err := openConnection(ctx, newWebsocketDialer(configuration), url, configuration)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	// the polling transport receives the open packet during the handshake
+	if configuration.Transport == PollingSignalTransport {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = connection.SetReadDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	_, message, err := connection.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if len(message) == 0 || message[0] != engineIOOpenPacket {
+		return errors.New("open packet expected")
+	}
+	return nil
+}
+
+func isDnsaddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(madns.DnsaddrProtocol.Code)
+	return err == nil
+}
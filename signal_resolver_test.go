@@ -0,0 +1,204 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+const testDnsaddr = "/dnsaddr/stars.example.com/p2p-webrtc-star"
+
+// testStarResolver resolves every address to the current set of star servers and counts the lookups.
+type testStarResolver struct {
+	m       sync.Mutex
+	addrs   []ma.Multiaddr
+	err     error
+	lookups int
+}
+
+func (r *testStarResolver) Resolve(context.Context, ma.Multiaddr) ([]ma.Multiaddr, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.lookups++
+	return r.addrs, r.err
+}
+
+func (r *testStarResolver) set(addrs []ma.Multiaddr, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.addrs, r.err = addrs, err
+}
+
+func (r *testStarResolver) lookupCount() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.lookups
+}
+
+func decodeTestPeerID(t *testing.T) peer.ID {
+	peerID, err := peer.IDB58Decode(testPeerID)
+	require.NoError(t, err)
+	return peerID
+}
+
+func unreachableStarMultiaddr(t *testing.T) ma.Multiaddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	addr, err := NewSignalMultiaddr("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, true)
+	require.NoError(t, err)
+	return addr
+}
+
+func TestStarEndpointsSelectReachableStar(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	resolver := &madns.Resolver{Backend: &madns.MockBackend{TXT: map[string][]string{
+		"_dnsaddr.stars.example.com": {
+			"dnsaddr=" + unreachableStarMultiaddr(t).String(),
+			"dnsaddr=" + server.signalMultiaddr().String(),
+			"dnsaddr=/dns4/other.example.com/tcp/443/wss",
+		},
+	}}}
+	dnsaddr, err := ma.NewMultiaddr(testDnsaddr)
+	require.NoError(t, err)
+
+	endpoints, err := newStarEndpoints(dnsaddr, decodeTestPeerID(t), server.signalConfiguration(), resolver)
+	require.NoError(t, err)
+	_, resolved := endpoints.current()
+	require.False(t, resolved)
+
+	// when
+	endpoint, err := endpoints.next(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, server.signalMultiaddr(), endpoint.signalMultiaddr)
+	assert.Equal(t, server.signalMultiaddr().String()+"/ipfs/"+testPeerID, endpoint.peerMultiaddr.String())
+}
+
+func TestStarEndpointsKeepPreviousStarIfResolutionFails(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	resolver := &testStarResolver{addrs: []ma.Multiaddr{server.signalMultiaddr()}}
+	dnsaddr, err := ma.NewMultiaddr(testDnsaddr)
+	require.NoError(t, err)
+
+	endpoints, err := newStarEndpoints(dnsaddr, decodeTestPeerID(t), server.signalConfiguration(), resolver)
+	require.NoError(t, err)
+	_, err = endpoints.next(context.Background())
+	require.NoError(t, err)
+
+	// when
+	resolver.set(nil, errors.New("lookup failed"))
+	endpoint, err := endpoints.next(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, server.signalMultiaddr(), endpoint.signalMultiaddr)
+	assert.Equal(t, 2, resolver.lookupCount())
+}
+
+func TestTransportListensOnDnsaddr(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	resolver := &testStarResolver{addrs: []ma.Multiaddr{server.signalMultiaddr()}}
+	listening := newTestTransport(t, server).WithStarResolver(resolver)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+	defer dialing.Close()
+
+	dnsaddr, err := ma.NewMultiaddr(testDnsaddr)
+	require.NoError(t, err)
+
+	// when
+	listener, err := listening.Listen(dnsaddr)
+	require.NoError(t, err)
+	require.Contains(t, receiveSignalMessage(t, server), `42["ss-join","`+server.signalMultiaddr().String())
+
+	server.dropSessions()
+	require.Contains(t, receiveSignalMessage(t, server), `42["ss-join","`+server.signalMultiaddr().String())
+
+	go listener.Accept()
+	_, err = dialing.Dial(context.Background(), listener.Multiaddr(), listening.peerID)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, server.signalMultiaddr(), listener.Multiaddr())
+	assert.Equal(t, 2, resolver.lookupCount())
+}
+
+func TestStarEndpointsSkipStarsWithoutSocketIO(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+	plainServer := newTestSignalTLSServer()
+	plainServer.Config.Handler = http.NotFoundHandler()
+	defer plainServer.Close()
+
+	resolver := &testStarResolver{addrs: []ma.Multiaddr{plainServer.signalMultiaddr(), server.signalMultiaddr()}}
+	dnsaddr, err := ma.NewMultiaddr(testDnsaddr)
+	require.NoError(t, err)
+
+	configuration := server.signalConfiguration()
+	configuration.TLSConfig.RootCAs.AddCert(plainServer.Certificate())
+	endpoints, err := newStarEndpoints(dnsaddr, decodeTestPeerID(t), configuration, resolver)
+	require.NoError(t, err)
+
+	// when
+	endpoint, err := endpoints.next(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, server.signalMultiaddr(), endpoint.signalMultiaddr)
+}
+
+func TestTransportDialsDnsaddrThroughAllStars(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+	otherServer := newTestSignalTLSServer()
+	defer otherServer.Close()
+
+	configuration := server.signalConfiguration()
+	configuration.TLSConfig.RootCAs.AddCert(otherServer.Certificate())
+
+	listening := newTestTransport(t, server).WithSignalConfiguration(configuration)
+	defer listening.Close()
+	resolver := &testStarResolver{addrs: []ma.Multiaddr{otherServer.signalMultiaddr()}}
+	dialing := newTestTransport(t, server).WithSignalConfiguration(configuration).WithStarResolver(resolver)
+	defer dialing.Close()
+
+	listener, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+	require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+	go listener.Accept()
+
+	dnsaddr, err := ma.NewMultiaddr(testDnsaddr)
+	require.NoError(t, err)
+	dnsaddrSignal, err := dialing.getOrRegisterSignal(dnsaddr)
+	require.NoError(t, err)
+	_, err = dnsaddrSignal.endpoints.wait(context.Background(), nil)
+	require.NoError(t, err)
+	require.Contains(t, receiveSignalMessage(t, otherServer), `42["ss-join"`)
+
+	// when
+	resolver.set([]ma.Multiaddr{otherServer.signalMultiaddr(), server.signalMultiaddr()}, nil)
+	conn, err := dialing.Dial(context.Background(), dnsaddr, listening.peerID)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, listening.peerID, conn.RemotePeer())
+}
@@ -0,0 +1,366 @@
+package star
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	ma "github.com/multiformats/go-multiaddr"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const testSignalURLPath = "/socket.io/?EIO=3&transport=websocket"
+
+// testSignalServer is a minimal Engine.IO v3 star supporting polling, WebSocket and the upgrade between them.
+// Socket.IO messages sent by clients are published on receivedCh. Joins and handshakes are routed like
+// the reference star does.
+type testSignalServer struct {
+	*httptest.Server
+
+	upgrader   websocket.Upgrader
+	receivedCh chan string
+
+	m        sync.Mutex
+	sessions map[string]*testSignalSession
+	peers    map[string]*testSignalSession
+	nextSID  int
+}
+
+type testSignalSession struct {
+	sid        string
+	outgoingCh chan []byte
+	closedCh   chan struct{}
+
+	m         sync.Mutex
+	websocket *websocket.Conn
+	upgraded  bool
+}
+
+func newTestSignalServer() *testSignalServer {
+	s := createTestSignalServer()
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func newTestSignalTLSServer() *testSignalServer {
+	s := createTestSignalServer()
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func createTestSignalServer() *testSignalServer {
+	return &testSignalServer{
+		receivedCh: make(chan string, 256),
+		sessions:   map[string]*testSignalSession{},
+		peers:      map[string]*testSignalSession{},
+	}
+}
+
+func (s *testSignalServer) signalURL() string {
+	return "ws://" + strings.TrimPrefix(s.URL, "http://") + testSignalURLPath
+}
+
+func (s *testSignalServer) signalMultiaddr() ma.Multiaddr {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		panic(err)
+	}
+
+	maddr, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/%s/wss/p2p-webrtc-star", u.Hostname(), u.Port()))
+	if err != nil {
+		panic(err)
+	}
+	return maddr
+}
+
+func (s *testSignalServer) signalConfiguration() SignalConfiguration {
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(s.Certificate())
+
+	return SignalConfiguration{
+		URLPath:   testSignalURLPath,
+		TLSConfig: &tls.Config{RootCAs: rootCAs},
+	}
+}
+
+func (s *testSignalServer) broadcast(packet string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, session := range s.sessions {
+		session.outgoingCh <- []byte(packet)
+	}
+}
+
+func (s *testSignalServer) session() *testSignalSession {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, session := range s.sessions {
+		return session
+	}
+	return nil
+}
+
+func (s *testSignalServer) newSession(upgrades []string) (*testSignalSession, []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.nextSID++
+	session := &testSignalSession{
+		sid:        fmt.Sprintf("sid-%d", s.nextSID),
+		outgoingCh: make(chan []byte, 64),
+		closedCh:   make(chan struct{}),
+	}
+	s.sessions[session.sid] = session
+
+	upgradesJSON := "[]"
+	if len(upgrades) > 0 {
+		upgradesJSON = `["` + strings.Join(upgrades, `","`) + `"]`
+	}
+	open := fmt.Sprintf(`0{"sid":"%s","upgrades":%s,"pingInterval":25000,"pingTimeout":5000}`, session.sid,
+		upgradesJSON)
+	return session, []byte(open)
+}
+
+// dropSessions closes all sessions without notice, so clients have to reconnect.
+func (s *testSignalServer) dropSessions() {
+	s.m.Lock()
+	sessions := s.sessions
+	s.sessions = map[string]*testSignalSession{}
+	s.peers = map[string]*testSignalSession{}
+	s.m.Unlock()
+
+	for _, session := range sessions {
+		close(session.closedCh)
+
+		session.m.Lock()
+		if session.websocket != nil {
+			session.websocket.Close()
+		}
+		session.m.Unlock()
+	}
+}
+
+func (s *testSignalServer) lookupSession(sid string) *testSignalSession {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.sessions[sid]
+}
+
+func (s *testSignalServer) handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sid := query.Get("sid")
+
+	if query.Get("transport") == "websocket" {
+		s.handleWebsocket(w, r, sid)
+		return
+	}
+
+	if sid == "" {
+		_, open := s.newSession([]string{"websocket"})
+		w.Write(encodeEngineIOPayload([][]byte{open, []byte("40")}))
+		return
+	}
+
+	session := s.lookupSession(sid)
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		packets, err := decodeEngineIOPayload(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, packet := range packets {
+			s.receive(session, packet)
+		}
+		w.Write([]byte("ok"))
+		return
+	}
+
+	select {
+	case packet := <-session.outgoingCh:
+		packets := [][]byte{packet}
+		for len(session.outgoingCh) > 0 {
+			packets = append(packets, <-session.outgoingCh)
+		}
+		w.Write(encodeEngineIOPayload(packets))
+	case <-session.closedCh:
+		http.Error(w, "session closed", http.StatusBadRequest)
+	case <-r.Context().Done():
+	case <-time.After(5 * time.Second):
+		w.Write(encodeEngineIOPayload([][]byte{{engineIONoopPacket}}))
+	}
+}
+
+func (s *testSignalServer) handleWebsocket(w http.ResponseWriter, r *http.Request, sid string) {
+	connection, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer connection.Close()
+
+	var session *testSignalSession
+	if sid == "" {
+		var open []byte
+		session, open = s.newSession(nil)
+		connection.WriteMessage(websocket.TextMessage, open)
+		connection.WriteMessage(websocket.TextMessage, []byte("40"))
+	} else {
+		session = s.lookupSession(sid)
+		if session == nil || !s.acceptUpgrade(session, connection) {
+			return
+		}
+	}
+
+	session.m.Lock()
+	session.websocket = connection
+	session.m.Unlock()
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		for {
+			select {
+			case <-doneCh:
+				return
+			case packet := <-session.outgoingCh:
+				session.write(packet)
+			}
+		}
+	}()
+
+	for {
+		_, packet, err := connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.receive(session, packet)
+	}
+}
+
+func (s *testSignalServer) acceptUpgrade(session *testSignalSession, connection *websocket.Conn) bool {
+	_, probe, err := connection.ReadMessage()
+	if err != nil || string(probe) != "2probe" {
+		return false
+	}
+	connection.WriteMessage(websocket.TextMessage, []byte("3probe"))
+
+	// Like Engine.IO, flush pending polls with noops until the client sends the upgrade packet.
+	upgradeCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		session.outgoingCh <- []byte{engineIONoopPacket}
+		for {
+			select {
+			case <-upgradeCh:
+				return
+			case <-ticker.C:
+				session.outgoingCh <- []byte{engineIONoopPacket}
+			}
+		}
+	}()
+
+	_, upgrade, err := connection.ReadMessage()
+	close(upgradeCh)
+	if err != nil || string(upgrade) != "5" {
+		return false
+	}
+
+	session.m.Lock()
+	session.upgraded = true
+	session.m.Unlock()
+	return true
+}
+
+func (s *testSignalServer) receive(session *testSignalSession, packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+
+	switch packet[0] {
+	case engineIOPingPacket:
+		session.outgoingCh <- append([]byte{engineIOPongPacket}, packet[1:]...)
+	case engineIOClosePacket:
+	default:
+		s.route(session, packet)
+		select {
+		case s.receivedCh <- string(packet):
+		default:
+		}
+	}
+}
+
+func (s *testSignalServer) route(session *testSignalSession, packet []byte) {
+	var message []json.RawMessage
+	if !bytes.HasPrefix(packet, []byte(messagePrefix)) || json.Unmarshal(packet[len(messagePrefix):], &message) != nil ||
+		len(message) < 2 {
+		return
+	}
+
+	var messageType string
+	json.Unmarshal(message[0], &messageType)
+
+	switch messageType {
+	case "ss-join":
+		var peerMultiaddr string
+		json.Unmarshal(message[1], &peerMultiaddr)
+
+		s.m.Lock()
+		s.peers[peerMultiaddr] = session
+		s.m.Unlock()
+	case "ss-handshake":
+		var offer handshakeData
+		json.Unmarshal(message[1], &offer)
+
+		s.m.Lock()
+		source := s.peers[offer.SrcMultiaddr]
+		destination := s.peers[offer.DstMultiaddr]
+		s.m.Unlock()
+
+		if offer.Answer {
+			destination = source
+		} else if destination == nil {
+			offer.Err = "peer is not available"
+			destination = source
+		}
+		if destination == nil {
+			return
+		}
+
+		data, _ := json.Marshal(offer)
+		destination.outgoingCh <- []byte(messagePrefix + `["ws-handshake",` + string(data) + `]`)
+	}
+}
+
+func (session *testSignalSession) write(packet []byte) {
+	session.m.Lock()
+	defer session.m.Unlock()
+	session.websocket.WriteMessage(websocket.TextMessage, packet)
+}
+
+func (session *testSignalSession) isUpgraded() bool {
+	session.m.Lock()
+	defer session.m.Unlock()
+	return session.upgraded
+}
@@ -3,32 +3,94 @@ package star
 import (
 	"github.com/pion/datachannel"
 	"io"
-	"math"
 	"net"
+	"sync"
 	"time"
 )
 
-const wrapperBufferSize = math.MaxUint16
+type streamConfiguration struct {
+	maxMessageSize             int
+	receiveBufferSize          int
+	maxBufferedAmount          uint64
+	bufferedAmountLowThreshold uint64
+
+	// keepalive is set if the remote peer advertised keepalives, text messages are passed on otherwise.
+	keepalive bool
+}
+
+// bufferedDataChannel is implemented by detached pion data channels.
+type bufferedDataChannel interface {
+	BufferedAmount() uint64
+	SetBufferedAmountLowThreshold(th uint64)
+	OnBufferedAmountLow(f func())
+}
 
 type stream struct {
-	id          string
-	dataChannel datachannel.ReadWriteCloser
-	address     net.Addr
+	id            string
+	dataChannel   datachannel.ReadWriteCloser
+	address       net.Addr
+	configuration streamConfiguration
 
 	buffer      []byte
 	bufferStart int
 	bufferEnd   int
+
+	bufferedAmountLowCh chan struct{}
+	writeDeadline       time.Time
+	writeDeadlineCh     chan struct{}
+	mWriteDeadline      sync.Mutex
+
+	lastReceived  time.Time
+	mLastReceived sync.Mutex
+
+	metrics   MetricsSink
+	closedCh  chan struct{}
+	closeOnce sync.Once
 }
 
 var _ net.Conn = new(stream)
 
-func newStream(dataChannel datachannel.ReadWriteCloser, address net.Addr) *stream {
-	return &stream{
-		id:          createRandomID("stream"),
-		dataChannel: dataChannel,
-		address:     address,
+type writeTimeoutError struct{}
+
+func (writeTimeoutError) Error() string   { return "write deadline exceeded" }
+func (writeTimeoutError) Timeout() bool   { return true }
+func (writeTimeoutError) Temporary() bool { return true }
+
+var errWriteTimeout net.Error = writeTimeoutError{}
+
+func newStream(dataChannel datachannel.ReadWriteCloser, address net.Addr, configuration streamConfiguration,
+	metrics MetricsSink) *stream {
+	incrementMetric(metrics, MetricDataChannelsActive)
+	s := &stream{
+		id:            createRandomID("stream"),
+		dataChannel:   dataChannel,
+		address:       address,
+		configuration: configuration,
+
+		buffer: make([]byte, configuration.receiveBufferSize),
+
+		bufferedAmountLowCh: make(chan struct{}, 1),
+		writeDeadlineCh:     make(chan struct{}, 1),
+
+		lastReceived: time.Now(),
+
+		metrics:  metrics,
+		closedCh: make(chan struct{}),
+	}
+
+	if buffered, ok := dataChannel.(bufferedDataChannel); ok && configuration.maxBufferedAmount > 0 {
+		buffered.SetBufferedAmountLowThreshold(configuration.bufferedAmountLowThreshold)
+		buffered.OnBufferedAmountLow(func() {
+			notify(s.bufferedAmountLowCh)
+		})
+	}
+	return s
+}
 
-		buffer: make([]byte, wrapperBufferSize),
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
 	}
 }
 
@@ -37,12 +99,13 @@ func (s *stream) Read(p []byte) (int, error) {
 
 	if s.bufferEnd == 0 {
 		n := 0
-		n, err = s.dataChannel.Read(s.buffer)
+		n, err = s.readMessage()
 		if err != nil {
 			logger.Errorf("Error occurred while reading from data channel: %v", err)
 			err = io.EOF
 		}
 		s.bufferEnd = n
+		s.metrics.Add(MetricStreamBytesReceived, float64(n))
 	}
 
 	n := 0
@@ -58,15 +121,117 @@ func (s *stream) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// readMessage reads the next message. With keepalives, text messages carry them and the muxer never sees them.
+func (s *stream) readMessage() (int, error) {
+	for {
+		n, isString, err := s.dataChannel.ReadDataChannel(s.buffer)
+		if err != nil {
+			return n, err
+		}
+
+		s.mLastReceived.Lock()
+		s.lastReceived = time.Now()
+		s.mLastReceived.Unlock()
+
+		if !isString || !s.configuration.keepalive {
+			return n, nil
+		}
+		if string(s.buffer[:n]) == keepalivePing {
+			_, err = s.dataChannel.WriteDataChannel([]byte(keepalivePong), true)
+			if err != nil {
+				logger.Debugf("%s: Can't answer keepalive: %v", s.id, err)
+			}
+		}
+	}
+}
+
+// ping sends a keepalive, which the remote peer answers. It skips the buffered amount limit.
+func (s *stream) ping() error {
+	_, err := s.dataChannel.WriteDataChannel([]byte(keepalivePing), true)
+	return err
+}
+
+// sinceReceived returns the time since the last message was received.
+func (s *stream) sinceReceived() time.Duration {
+	s.mLastReceived.Lock()
+	defer s.mLastReceived.Unlock()
+	return time.Since(s.lastReceived)
+}
+
+// Write splits p into messages no larger than the negotiated message size. It blocks while the data channel
+// buffers more than maxBufferedAmount bytes, until the buffered amount drops below the low threshold.
 func (s *stream) Write(p []byte) (int, error) {
-	if len(p) > wrapperBufferSize {
-		return s.dataChannel.Write(p[:wrapperBufferSize])
+	written := 0
+	for written < len(p) {
+		err := s.waitForBufferedAmountLow()
+		if err != nil {
+			return written, err
+		}
+
+		message := p[written:]
+		if s.configuration.maxMessageSize > 0 && len(message) > s.configuration.maxMessageSize {
+			message = message[:s.configuration.maxMessageSize]
+		}
+
+		n, err := s.dataChannel.Write(message)
+		written += n
+		s.metrics.Add(MetricStreamBytesSent, float64(n))
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (s *stream) waitForBufferedAmountLow() error {
+	buffered, ok := s.dataChannel.(bufferedDataChannel)
+	if !ok || s.configuration.maxBufferedAmount == 0 {
+		return nil
+	}
+
+	for buffered.BufferedAmount() >= s.configuration.maxBufferedAmount {
+		s.mWriteDeadline.Lock()
+		deadline := s.writeDeadline
+		s.mWriteDeadline.Unlock()
+
+		err := s.waitForEvent(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForEvent returns when the buffered amount drops, the deadline changes or expires, or the stream is closed.
+func (s *stream) waitForEvent(deadline time.Time) error {
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return errWriteTimeout
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case <-s.bufferedAmountLowCh:
+	case <-s.writeDeadlineCh:
+	case <-timeoutCh:
+		return errWriteTimeout
+	case <-s.closedCh:
+		return io.ErrClosedPipe
 	}
-	return s.dataChannel.Write(p)
+	return nil
 }
 
 func (s *stream) Close() error {
 	logger.Warningf("%s: Close stream", s.id)
+	s.closeOnce.Do(func() {
+		close(s.closedCh)
+		decrementMetric(s.metrics, MetricDataChannelsActive)
+	})
 	return s.dataChannel.Close()
 }
 
@@ -78,14 +243,20 @@ func (s *stream) RemoteAddr() net.Addr {
 	return s.address
 }
 
-func (s *stream) SetDeadline(time.Time) error {
-	return nil
+func (s *stream) SetDeadline(t time.Time) error {
+	return s.SetWriteDeadline(t)
 }
 
 func (s *stream) SetReadDeadline(time.Time) error {
 	return nil
 }
 
-func (s *stream) SetWriteDeadline(time.Time) error {
+// SetWriteDeadline limits the time a Write waits for the buffered amount to drop.
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mWriteDeadline.Lock()
+	s.writeDeadline = t
+	s.mWriteDeadline.Unlock()
+
+	notify(s.writeDeadlineCh)
 	return nil
 }
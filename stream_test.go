@@ -0,0 +1,208 @@
+package star
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedMessage struct {
+	data     []byte
+	isString bool
+}
+
+type recordingDataChannel struct {
+	m            sync.Mutex
+	messages     [][]byte
+	textMessages []string
+	incoming     []recordedMessage
+
+	bufferedAmount             uint64
+	bufferedAmountLowThreshold uint64
+	onBufferedAmountLow        func()
+}
+
+func (r *recordingDataChannel) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (r *recordingDataChannel) ReadDataChannel(p []byte) (int, bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(r.incoming) == 0 {
+		return 0, false, io.EOF
+	}
+	message := r.incoming[0]
+	r.incoming = r.incoming[1:]
+	return copy(p, message.data), message.isString, nil
+}
+
+func (r *recordingDataChannel) Write(p []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.messages = append(r.messages, append([]byte(nil), p...))
+	r.bufferedAmount += uint64(len(p))
+	return len(p), nil
+}
+
+func (r *recordingDataChannel) WriteDataChannel(p []byte, isString bool) (int, error) {
+	if !isString {
+		return r.Write(p)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.textMessages = append(r.textMessages, string(p))
+	return len(p), nil
+}
+
+func (r *recordingDataChannel) Close() error {
+	return nil
+}
+
+func (r *recordingDataChannel) BufferedAmount() uint64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.bufferedAmount
+}
+
+func (r *recordingDataChannel) SetBufferedAmountLowThreshold(th uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.bufferedAmountLowThreshold = th
+}
+
+func (r *recordingDataChannel) OnBufferedAmountLow(f func()) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.onBufferedAmountLow = f
+}
+
+// release simulates the remote peer acknowledging all buffered data.
+func (r *recordingDataChannel) release() {
+	r.m.Lock()
+	r.bufferedAmount = 0
+	f := r.onBufferedAmountLow
+	r.m.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
+
+func (r *recordingDataChannel) messageCount() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return len(r.messages)
+}
+
+func TestStreamSplitsWritesIntoMessages(t *testing.T) {
+	dataChannel := &recordingDataChannel{}
+	s := newStream(dataChannel, fakeNetAddress, streamConfiguration{maxMessageSize: 1000, receiveBufferSize: 1000},
+		noopMetricsSink{})
+	data := make([]byte, 2500)
+
+	// when
+	n, err := s.Write(data)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	require.Len(t, dataChannel.messages, 3)
+	assert.Len(t, dataChannel.messages[0], 1000)
+	assert.Len(t, dataChannel.messages[1], 1000)
+	assert.Len(t, dataChannel.messages[2], 500)
+}
+
+func TestStreamWriteBlocksUntilBufferedAmountLow(t *testing.T) {
+	dataChannel := &recordingDataChannel{}
+	s := newStream(dataChannel, fakeNetAddress, streamConfiguration{
+		maxMessageSize:             1000,
+		receiveBufferSize:          1000,
+		maxBufferedAmount:          2000,
+		bufferedAmountLowThreshold: 1000,
+	}, noopMetricsSink{})
+	assert.Equal(t, uint64(1000), dataChannel.bufferedAmountLowThreshold)
+
+	writtenCh := make(chan int, 1)
+	go func() {
+		n, _ := s.Write(make([]byte, 3000))
+		writtenCh <- n
+	}()
+
+	// when
+	time.Sleep(100 * time.Millisecond)
+	blockedMessages := dataChannel.messageCount()
+	dataChannel.release()
+
+	// then
+	assert.Equal(t, 2, blockedMessages)
+	select {
+	case n := <-writtenCh:
+		assert.Equal(t, 3000, n)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write still blocked")
+	}
+}
+
+func TestStreamWriteRespectsDeadline(t *testing.T) {
+	dataChannel := &recordingDataChannel{bufferedAmount: 5000}
+	s := newStream(dataChannel, fakeNetAddress, streamConfiguration{
+		maxMessageSize:             1000,
+		receiveBufferSize:          1000,
+		maxBufferedAmount:          2000,
+		bufferedAmountLowThreshold: 1000,
+	}, noopMetricsSink{})
+	require.NoError(t, s.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+
+	// when
+	n, err := s.Write(make([]byte, 1000))
+
+	// then
+	assert.Equal(t, 0, n)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestStreamAnswersKeepalives(t *testing.T) {
+	dataChannel := &recordingDataChannel{incoming: []recordedMessage{
+		{data: []byte(keepalivePing), isString: true},
+		{data: []byte(keepalivePong), isString: true},
+		{data: []byte("muxed data")},
+	}}
+	s := newStream(dataChannel, fakeNetAddress, streamConfiguration{receiveBufferSize: 1000, keepalive: true},
+		noopMetricsSink{})
+
+	// when
+	data, err := ioutil.ReadAll(s)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "muxed data", string(data))
+	assert.Equal(t, []string{keepalivePong}, dataChannel.textMessages)
+	assert.Empty(t, dataChannel.messages)
+}
+
+func TestStreamPassesTextMessagesWithoutKeepalives(t *testing.T) {
+	dataChannel := &recordingDataChannel{incoming: []recordedMessage{
+		{data: []byte(keepalivePing), isString: true},
+		{data: []byte(" muxed data")},
+	}}
+	s := newStream(dataChannel, fakeNetAddress, streamConfiguration{receiveBufferSize: 1000}, noopMetricsSink{})
+
+	// when
+	data, err := ioutil.ReadAll(s)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, keepalivePing+" muxed data", string(data))
+	assert.Empty(t, dataChannel.textMessages)
+}
@@ -2,61 +2,119 @@ package star
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/transport"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multiaddr-dns"
 	"github.com/pion/webrtc/v2"
+	"io"
 	"sync"
+	"time"
 )
 
 type Transport struct {
-	signals map[string]*signal
-	m       sync.Mutex
+	signals            map[string]*signal
+	peerConnectionPool *peerConnectionPool
+	closed             bool
+	m                  sync.Mutex
+
+	connections       map[string]*connection
+	connectionsClosed bool
+	mConnections      sync.Mutex
+
+	dials  map[peer.ID]*peerDial
+	mDials sync.Mutex
+
+	dataChannelHandlers  map[string]DataChannelHandler
+	mDataChannelHandlers sync.RWMutex
+
+	trackHandler  TrackHandler
+	mTrackHandler sync.RWMutex
 
 	addressBook addressBook
 	peerID      peer.ID
 
 	signalConfiguration SignalConfiguration
+	resolver            StarResolver
 	webRTCConfiguration webrtc.Configuration
+	poolConfiguration   PeerConnectionPoolConfiguration
+	dataChannel         DataChannelConfiguration
 	multiplexer         mux.Multiplexer
+
+	sessionDescriptionHook SessionDescriptionHook
+	iceRecoveryWindow      time.Duration
+	idleTimeout            time.Duration
+	keepalive              KeepaliveConfiguration
+	metrics                MetricsSink
+	events                 *eventEmitters
+
+	// configurationErr is returned by Dial and Listen if a builder couldn't apply its configuration.
+	configurationErr error
 }
 
-var _ transport.Transport = new(Transport)
+const drainPollInterval = 100 * time.Millisecond
 
+var (
+	_ transport.Transport = new(Transport)
+	_ io.Closer           = new(Transport)
+)
+
+// Dial connects to the peer through the star of raddr. Concurrent dials to the same peer are coalesced, all of
+// them return the connection established first.
 func (t *Transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
 	logger.Debugf("Dial peer (ID: %s, address: %v)", p, raddr)
 	signal, err := t.getOrRegisterSignal(raddr)
 	if err != nil {
-		return nil, err
+		return nil, wrapOpError("dial", p, "", err)
+	}
+
+	conn, err := t.dialCoalesced(ctx, signal, p)
+	if errors.Is(err, ErrPeerNotPresent) && isDnsaddr(signal.endpoints.addr) {
+		return t.dialResolvedStars(ctx, signal, p, err)
 	}
-	return signal.dial(ctx, p)
+	return conn, err
 }
 
 func (t *Transport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
 	logger.Debugf("Listen on address: %s", laddr)
 	signal, err := t.getOrRegisterSignal(laddr)
 	if err != nil {
-		return nil, err
+		return nil, wrapOpError("listen", "", "", err)
 	}
+	signal.listen()
 	return newListener(laddr, signal, t.unregisterSignal)
 }
 
 func (t *Transport) getOrRegisterSignal(addr ma.Multiaddr) (*signal, error) {
-	var err error
-
+	starAddr, err := SplitStarAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	addr = starAddr.Signal
 	sAddr := addr.String()
 
 	t.m.Lock()
 	defer t.m.Unlock()
 
+	if t.closed {
+		return nil, ErrTransportClosed
+	}
+	if t.configurationErr != nil {
+		return nil, t.configurationErr
+	}
 	if signal, ok := t.signals[sAddr]; ok {
 		return signal, nil
 	}
+	if t.peerConnectionPool == nil && t.poolConfiguration.Size > 0 {
+		t.peerConnectionPool = newPeerConnectionPool(t.poolConfiguration, t.webRTCConfiguration, t.metrics)
+	}
 
-	t.signals[sAddr], err = newSignal(t, addr, t.addressBook, t.peerID, t.signalConfiguration, t.webRTCConfiguration,
-		t.multiplexer)
+	t.signals[sAddr], err = newSignal(t, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -67,21 +125,165 @@ func (t *Transport) unregisterSignal(addr ma.Multiaddr) error {
 	sAddr := addr.String()
 
 	t.m.Lock()
-	defer t.m.Unlock()
+	signal, ok := t.signals[sAddr]
+	delete(t.signals, sAddr)
+	closed := t.closed
+	t.m.Unlock()
 
-	if signal, ok := t.signals[sAddr]; ok {
-		err := signal.close()
-		if err != nil {
-			logger.Errorf("Error while closing signal: %v", err)
+	if !ok && closed {
+		return nil // already closed with the transport
+	} else if !ok {
+		return fmt.Errorf(`no signal registered for "%s"`, sAddr)
+	}
+
+	err := signal.close()
+	if err != nil {
+		logger.Errorf("Error while closing signal: %v", err)
+	}
+	return nil
+}
+
+func (t *Transport) registerConnection(c *connection) error {
+	t.mConnections.Lock()
+	defer t.mConnections.Unlock()
+
+	if t.connectionsClosed {
+		return ErrTransportClosed
+	}
+	t.connections[c.id] = c
+	return nil
+}
+
+func (t *Transport) unregisterConnection(c *connection) {
+	t.mConnections.Lock()
+	defer t.mConnections.Unlock()
+
+	delete(t.connections, c.id)
+}
+
+// lookupHandshakeConnection finds the open connection established by the handshake with the intent ID.
+func (t *Transport) lookupHandshakeConnection(remotePeerID peer.ID, intentID string) (*connection, bool) {
+	t.mConnections.Lock()
+	defer t.mConnections.Unlock()
+
+	for _, c := range t.connections {
+		if c.configuration.intentID == intentID && c.RemotePeer() == remotePeerID && !c.IsClosed() {
+			return c, true
 		}
-		delete(t.signals, sAddr)
-		return nil
 	}
-	return fmt.Errorf(`no signal registered for "%s"`, sAddr)
+	return nil, false
+}
+
+// LookupConn finds the star connection backing a libp2p connection. Stats and other WebRTC specific
+// features are available through the returned Conn.
+func (t *Transport) LookupConn(conn network.Conn) (Conn, bool) {
+	t.mConnections.Lock()
+	defer t.mConnections.Unlock()
+
+	for _, c := range t.connections {
+		if c.RemotePeer() == conn.RemotePeer() && c.LocalPeer() == conn.LocalPeer() &&
+			c.RemoteMultiaddr().Equal(conn.RemoteMultiaddr()) && !c.IsClosed() {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Close stops accepting, disconnects from all signal servers, closes every tracked connection and waits for
+// the signal clients to exit. Event emitters created by WithEventBus are closed last.
+func (t *Transport) Close() error {
+	t.closeSignals()
+	defer t.events.close()
+	return t.closeConnections()
+}
+
+// Drain works like Close, but lets open streams finish first. New outbound streams are refused. If ctx is done
+// before all streams are closed, remaining connections are closed anyway and ctx.Err() is returned.
+func (t *Transport) Drain(ctx context.Context) error {
+	t.closeSignals()
+	defer t.events.close()
+
+	t.mConnections.Lock()
+	for _, c := range t.connections {
+		c.drain()
+	}
+	t.mConnections.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for t.activeStreams() > 0 {
+		select {
+		case <-ctx.Done():
+			t.closeConnections()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return t.closeConnections()
+}
+
+func (t *Transport) closeSignals() {
+	t.m.Lock()
+	t.closed = true
+	signals := t.signals
+	t.signals = map[string]*signal{}
+	pool := t.peerConnectionPool
+	t.peerConnectionPool = nil
+	t.m.Unlock()
+
+	if pool != nil {
+		defer pool.close()
+	}
+
+	var wg sync.WaitGroup
+	for sAddr, s := range signals {
+		wg.Add(1)
+		go func(sAddr string, s *signal) {
+			defer wg.Done()
+
+			logger.Debugf("Close signal: %s", sAddr)
+			err := s.close()
+			if err != nil {
+				logger.Errorf("Error while closing signal: %v", err)
+			}
+		}(sAddr, s)
+	}
+	wg.Wait()
+}
+
+func (t *Transport) closeConnections() error {
+	t.mConnections.Lock()
+	t.connectionsClosed = true
+	connections := make([]*connection, 0, len(t.connections))
+	for _, c := range t.connections {
+		connections = append(connections, c)
+	}
+	t.mConnections.Unlock()
+
+	var firstErr error
+	for _, c := range connections {
+		err := c.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *Transport) activeStreams() int {
+	t.mConnections.Lock()
+	defer t.mConnections.Unlock()
+
+	var count int
+	for _, c := range t.connections {
+		count += c.activeStreams()
+	}
+	return count
 }
 
 func (t *Transport) CanDial(addr ma.Multiaddr) bool {
-	return format.Matches(addr)
+	return IsStarAddr(addr)
 }
 
 func (t *Transport) Protocols() []int {
@@ -95,9 +297,17 @@ func (t *Transport) Proxy() bool {
 func New(peerID peer.ID, peerstore addressBook, multiplexer mux.Multiplexer) *Transport {
 	return &Transport{
 		signals:     map[string]*signal{},
-		peerID:      peerID,
-		addressBook: peerstore,
-		multiplexer: multiplexer,
+		connections: map[string]*connection{},
+		dials:       map[peer.ID]*peerDial{},
+
+		dataChannelHandlers: map[string]DataChannelHandler{},
+		peerID:              peerID,
+		addressBook:         peerstore,
+		multiplexer:         multiplexer,
+		resolver:            madns.DefaultResolver,
+
+		iceRecoveryWindow: defaultICERecoveryWindow,
+		metrics:           noopMetricsSink{},
 	}
 }
 
@@ -106,7 +316,72 @@ func (t *Transport) WithSignalConfiguration(c SignalConfiguration) *Transport {
 	return t
 }
 
+// WithStarResolver replaces the resolver of /dnsaddr star addresses (default: madns.DefaultResolver).
+func (t *Transport) WithStarResolver(resolver StarResolver) *Transport {
+	t.resolver = resolver
+	return t
+}
+
 func (t *Transport) WithWebRTCConfiguration(c webrtc.Configuration) *Transport {
 	t.webRTCConfiguration = c
 	return t
 }
+
+// WithPeerConnectionPool keeps prewarmed peer connections to cut the handshake latency.
+func (t *Transport) WithPeerConnectionPool(c PeerConnectionPoolConfiguration) *Transport {
+	t.poolConfiguration = c
+	return t
+}
+
+// WithDataChannelConfiguration sets the parameters of the data channel carrying the muxed connection.
+func (t *Transport) WithDataChannelConfiguration(c DataChannelConfiguration) *Transport {
+	t.dataChannel = c
+	return t
+}
+
+func (t *Transport) WithSessionDescriptionHook(hook SessionDescriptionHook) *Transport {
+	t.sessionDescriptionHook = hook
+	return t
+}
+
+func (t *Transport) WithICERecoveryWindow(window time.Duration) *Transport {
+	t.iceRecoveryWindow = window
+	return t
+}
+
+// WithIdleTimeout closes connections which had no open streams for the timeout (default: 0, never).
+func (t *Transport) WithIdleTimeout(timeout time.Duration) *Transport {
+	t.idleTimeout = timeout
+	return t
+}
+
+// WithKeepalive closes connections to peers which stopped answering keepalives. Only peers advertising
+// keepalives in the handshake are pinged.
+func (t *Transport) WithKeepalive(c KeepaliveConfiguration) *Transport {
+	t.keepalive = c
+	return t
+}
+
+// WithMetricsSink reports the metrics listed in Metrics to the sink. A nil sink disables metrics.
+func (t *Transport) WithMetricsSink(sink MetricsSink) *Transport {
+	if sink == nil {
+		sink = noopMetricsSink{}
+	}
+	t.metrics = sink
+	return t
+}
+
+// WithEventBus publishes signal, handshake and connection events on the bus. Events are emitted synchronously by
+// the signal client and WebRTC callbacks, so a subscriber which doesn't drain its channel stalls signalling
+// (subscribe with eventbus.BufSize to absorb bursts). If the emitters can't be created, Dial and Listen fail.
+func (t *Transport) WithEventBus(bus event.Bus) *Transport {
+	events, err := newEventEmitters(bus)
+	if err != nil {
+		t.configurationErr = fmt.Errorf("can't create event emitters: %w", err)
+		return t
+	}
+
+	t.events.close()
+	t.events = events
+	return t
+}
@@ -0,0 +1,202 @@
+package star
+
+import (
+	"context"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	"sync"
+	"time"
+)
+
+const dialStagger = 250 * time.Millisecond
+
+// peerDial coalesces concurrent dials to the same peer. Every Dial call races an offer through its own star,
+// each one started dialStagger after the previous one. The first connection wins, the other attempts are
+// cancelled and their peer connections closed.
+//
+// Every caller gets the winning connection. libp2p's swarm closes connections returned to dials it stopped
+// waiting for, so if more than one caller is waiting, each one gets a sharedConn reference and the connection is
+// only closed once all of them are.
+type peerDial struct {
+	m         sync.Mutex
+	attempts  int
+	started   int
+	startTime time.Time
+
+	wonCh  chan struct{}
+	conn   transport.CapableConn
+	shares *connectionShares
+}
+
+// joinPeerDial registers a dial attempt and returns how long it should wait before sending an offer.
+func (t *Transport) joinPeerDial(p peer.ID) (*peerDial, time.Duration) {
+	t.mDials.Lock()
+	defer t.mDials.Unlock()
+
+	pd, ok := t.dials[p]
+	if !ok {
+		pd = &peerDial{
+			startTime: time.Now(),
+			wonCh:     make(chan struct{}),
+		}
+		t.dials[p] = pd
+	}
+
+	pd.m.Lock()
+	defer pd.m.Unlock()
+
+	delay := time.Until(pd.startTime.Add(time.Duration(pd.started) * dialStagger))
+	pd.attempts++
+	pd.started++
+	return pd, delay
+}
+
+// leavePeerDial unregisters a dial attempt. An attempt which didn't take its reference to the winning
+// connection releases it.
+func (t *Transport) leavePeerDial(p peer.ID, pd *peerDial, taken bool) {
+	t.mDials.Lock()
+	pd.m.Lock()
+	pd.attempts--
+	if pd.attempts == 0 && t.dials[p] == pd {
+		delete(t.dials, p)
+	}
+	release := pd.lost() && !taken && pd.shares != nil
+	pd.m.Unlock()
+	t.mDials.Unlock()
+
+	if release {
+		err := pd.shares.release()
+		if err != nil {
+			logger.Debugf("Can't close shared connection (peerID: %s): %v", p, err)
+		}
+	}
+}
+
+// win reports whether the attempt is the first one to connect. The winning connection is shared by all
+// attempts registered by then, new dials to the peer start over.
+func (t *Transport) win(p peer.ID, pd *peerDial, conn transport.CapableConn) bool {
+	t.mDials.Lock()
+	defer t.mDials.Unlock()
+
+	pd.m.Lock()
+	defer pd.m.Unlock()
+
+	if pd.lost() {
+		return false
+	}
+	if t.dials[p] == pd {
+		delete(t.dials, p)
+	}
+	pd.conn = conn
+	if pd.attempts > 1 {
+		pd.shares = newConnectionShares(conn.(*connection), pd.attempts)
+	}
+	close(pd.wonCh)
+	return true
+}
+
+func (pd *peerDial) lost() bool {
+	select {
+	case <-pd.wonCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// take returns the winning connection, or a reference to it if it's shared.
+func (pd *peerDial) take() transport.CapableConn {
+	if pd.shares == nil {
+		return pd.conn
+	}
+	return pd.shares.share()
+}
+
+func (t *Transport) dialCoalesced(ctx context.Context, signal *signal, p peer.ID) (transport.CapableConn, error) {
+	pd, delay := t.joinPeerDial(p)
+	taken := false
+	defer func() {
+		t.leavePeerDial(p, pd, taken)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	select {
+	case <-pd.wonCh:
+		taken = true
+		return t.coalescedConn(pd), nil
+	case <-ctx.Done():
+		return nil, wrapOpError("dial", p, "", ctx.Err())
+	case <-time.After(delay):
+	}
+
+	go func() {
+		select {
+		case <-pd.wonCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, err := signal.dial(ctx, p)
+	if err != nil {
+		if pd.lost() {
+			taken = true
+			return t.coalescedConn(pd), nil
+		}
+		return nil, err
+	}
+
+	taken = true
+	if !t.win(p, pd, conn) {
+		logger.Debugf("Close redundant connection (peerID: %s)", p)
+		conn.Close()
+		return t.coalescedConn(pd), nil
+	}
+	return pd.take(), nil
+}
+
+// coalescedConn returns the connection of the winning attempt to a losing one.
+func (t *Transport) coalescedConn(pd *peerDial) transport.CapableConn {
+	incrementMetric(t.metrics, MetricDialsCoalesced)
+	return pd.take()
+}
+
+// dialResolvedStars dials the peer through the other star servers behind the /dnsaddr address of the signal. The
+// peer may have joined another star, as both ends select the star on their own. Each star gets a signal of its
+// own, like a concrete address passed to Dial.
+func (t *Transport) dialResolvedStars(ctx context.Context, dnsaddrSignal *signal, p peer.ID,
+	lastErr error) (transport.CapableConn, error) {
+	stars, err := resolveStars(ctx, t.resolver, dnsaddrSignal.endpoints.addr)
+	if err != nil {
+		logger.Debugf("Can't resolve %s: %v", dnsaddrSignal.endpoints.addr, err)
+		return nil, lastErr
+	}
+
+	var tried ma.Multiaddr
+	if endpoint, ok := dnsaddrSignal.endpoints.current(); ok {
+		tried = endpoint.signalMultiaddr
+	}
+
+	for _, star := range stars {
+		if tried != nil && star.Equal(tried) {
+			continue
+		}
+
+		logger.Debugf("Dial peer through another star (ID: %s, address: %s)", p, star)
+		signal, err := t.getOrRegisterSignal(star)
+		if err != nil {
+			return nil, wrapOpError("dial", p, "", err)
+		}
+
+		conn, err := t.dialCoalesced(ctx, signal, p)
+		if !errors.Is(err, ErrPeerNotPresent) {
+			return conn, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
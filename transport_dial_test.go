@@ -0,0 +1,184 @@
+package star
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransportCoalescesConcurrentDials(t *testing.T) {
+	first := newTestSignalTLSServer()
+	defer first.Close()
+	second := newTestSignalTLSServer()
+	defer second.Close()
+
+	configuration := first.signalConfiguration()
+	configuration.TLSConfig.RootCAs.AddCert(second.Certificate())
+
+	listening := newTestTransport(t, first).WithSignalConfiguration(configuration)
+	defer listening.Close()
+	metrics := newRecordingMetricsSink()
+	dialing := newTestTransport(t, first).WithSignalConfiguration(configuration).WithMetricsSink(metrics)
+	defer dialing.Close()
+
+	for _, server := range []*testSignalServer{first, second} {
+		listener, err := listening.Listen(server.signalMultiaddr())
+		require.NoError(t, err)
+		require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+
+		go func() {
+			for {
+				if _, err := listener.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	type dialResult struct {
+		conn transport.CapableConn
+		err  error
+	}
+
+	// when
+	resultCh := make(chan dialResult, 2)
+	for _, signalMultiaddr := range []ma.Multiaddr{first.signalMultiaddr(), second.signalMultiaddr()} {
+		go func(signalMultiaddr ma.Multiaddr) {
+			conn, err := dialing.Dial(context.Background(), signalMultiaddr, listening.peerID)
+			resultCh <- dialResult{conn: conn, err: err}
+		}(signalMultiaddr)
+	}
+
+	// then
+	var conns []transport.CapableConn
+	for i := 0; i < 2; i++ {
+		result := <-resultCh
+		require.NoError(t, result.err)
+		conns = append(conns, result.conn)
+	}
+	assert.Equal(t, float64(1), metrics.value(MetricDialsCoalesced))
+	assert.Eventually(t, func() bool {
+		return metrics.value(MetricPeerConnectionsActive) == 1 && pendingHandshakes(dialing) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, conns[0].Close())
+	assert.True(t, conns[0].IsClosed())
+	assert.False(t, conns[1].IsClosed(), "connection closed under the other caller")
+	_, err := conns[1].OpenStream()
+	assert.NoError(t, err)
+
+	require.NoError(t, conns[1].Close())
+	assert.Equal(t, float64(0), metrics.value(MetricPeerConnectionsActive))
+}
+
+func TestTransportStaggersCoalescedDials(t *testing.T) {
+	first := newTestSignalTLSServer()
+	defer first.Close()
+	second := newTestSignalTLSServer()
+	defer second.Close()
+
+	configuration := first.signalConfiguration()
+	configuration.TLSConfig.RootCAs.AddCert(second.Certificate())
+
+	listening := newTestTransport(t, second).WithSignalConfiguration(configuration)
+	defer listening.Close()
+	metrics := newRecordingMetricsSink()
+	dialing := newTestTransport(t, first).WithSignalConfiguration(configuration).WithMetricsSink(metrics)
+	defer dialing.Close()
+
+	listener, err := listening.Listen(second.signalMultiaddr())
+	require.NoError(t, err)
+	require.Contains(t, receiveSignalMessage(t, second), `42["ss-join"`)
+	acceptedCh := make(chan transport.CapableConn, 1)
+	go func() {
+		accepted, err := listener.Accept()
+		if err == nil {
+			acceptedCh <- accepted
+		}
+		close(acceptedCh)
+	}()
+
+	// the first star delivers the offer, but the answer never comes back
+	first.m.Lock()
+	first.peers[createPeerMultiaddr(first.signalMultiaddr(), listening.peerID).String()] = &testSignalSession{
+		outgoingCh: make(chan []byte, 16),
+	}
+	first.m.Unlock()
+
+	type dialResult struct {
+		conn transport.CapableConn
+		err  error
+	}
+	dial := func(signalMultiaddr ma.Multiaddr, resultCh chan<- dialResult) {
+		conn, err := dialing.Dial(context.Background(), signalMultiaddr, listening.peerID)
+		resultCh <- dialResult{conn: conn, err: err}
+	}
+
+	// when
+	firstResultCh := make(chan dialResult, 1)
+	go dial(first.signalMultiaddr(), firstResultCh)
+	firstOfferTime := awaitSignalOffer(t, first)
+
+	secondResultCh := make(chan dialResult, 1)
+	go dial(second.signalMultiaddr(), secondResultCh)
+	secondOfferTime := awaitSignalOffer(t, second)
+
+	// then
+	assert.True(t, secondOfferTime.Sub(firstOfferTime) >= dialStagger-50*time.Millisecond,
+		"offers sent %v apart", secondOfferTime.Sub(firstOfferTime))
+
+	won := <-secondResultCh
+	require.NoError(t, won.err)
+	lost := <-firstResultCh
+	require.NoError(t, lost.err)
+	defer won.conn.Close()
+	defer lost.conn.Close()
+
+	assert.Equal(t, listening.peerID, lost.conn.RemotePeer())
+	assert.Equal(t, won.conn.(*sharedConn).connection, lost.conn.(*sharedConn).connection)
+	assert.Equal(t, float64(1), metrics.value(MetricDialsCoalesced))
+
+	// the peer connection of the losing offer is closed
+	assert.Eventually(t, func() bool {
+		return metrics.value(MetricPeerConnectionsActive) == 1 && pendingHandshakes(dialing) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// streams opened by the remote peer reach the caller which accepts them
+	accepted, ok := <-acceptedCh
+	require.True(t, ok, "connection not accepted")
+	remoteStream, err := accepted.OpenStream()
+	require.NoError(t, err)
+	_, err = remoteStream.Write([]byte("ping"))
+	require.NoError(t, err)
+	stream, err := lost.conn.AcceptStream()
+	require.NoError(t, err)
+	_, err = io.ReadFull(stream, make([]byte, 4))
+	assert.NoError(t, err)
+}
+
+func awaitSignalOffer(t *testing.T, server *testSignalServer) time.Time {
+	for {
+		if strings.Contains(receiveSignalMessage(t, server), `42["ss-handshake"`) {
+			return time.Now()
+		}
+	}
+}
+
+func pendingHandshakes(t *Transport) int {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	var pending int
+	for _, s := range t.signals {
+		s.handshakeSubscription.m.Lock()
+		pending += len(s.handshakeSubscription.subscribers)
+		s.handshakeSubscription.m.Unlock()
+	}
+	return pending
+}
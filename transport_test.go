@@ -0,0 +1,430 @@
+package star
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/transport"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p-yamux"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestTransport(t testing.TB, server *testSignalServer) *Transport {
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	peerID, err := peer.IDFromPrivateKey(privKey)
+	require.NoError(t, err)
+
+	return New(peerID, pstoremem.NewPeerstore(), sm_yamux.DefaultTransport).
+		WithSignalConfiguration(server.signalConfiguration())
+}
+
+// connectTestTransports dials the listening transport and returns both sides of the connection.
+func connectTestTransports(t *testing.T, server *testSignalServer, listening, dialing *Transport,
+	options ...DialOption) (transport.CapableConn, transport.CapableConn) {
+	listener, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+
+	acceptedCh := make(chan transport.CapableConn, 1)
+	go func() {
+		accepted, err := listener.Accept()
+		if err == nil {
+			acceptedCh <- accepted
+		}
+		close(acceptedCh)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctx = WithDialOptions(ctx, options...)
+
+	var dialed transport.CapableConn
+	for dialed == nil { // the listener may not have joined yet
+		dialed, err = dialing.Dial(ctx, server.signalMultiaddr(), listening.peerID)
+		if errors.Is(err, ErrPeerNotPresent) {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		require.NoError(t, err)
+	}
+
+	accepted, ok := <-acceptedCh
+	require.True(t, ok, "connection not accepted")
+	return accepted, dialed
+}
+
+func TestTransportClose(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	tr := newTestTransport(t, server)
+	listener, err := tr.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+	require.Contains(t, receiveSignalMessage(t, server), `42["ss-join"`)
+
+	acceptErrCh := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		acceptErrCh <- err
+	}()
+
+	// when
+	err = tr.Close()
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, errors.Is(<-acceptErrCh, ErrTransportClosed))
+	assert.Equal(t, disconnectMessage, receiveSignalMessage(t, server))
+
+	_, err = tr.Dial(context.Background(), server.signalMultiaddr(), tr.peerID)
+	assert.True(t, errors.Is(err, ErrTransportClosed))
+	_, err = tr.Listen(server.signalMultiaddr())
+	assert.True(t, errors.Is(err, ErrTransportClosed))
+	assert.NoError(t, listener.Close())
+}
+
+func TestTransportCloseClosesConnections(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// when
+	err := dialing.Close()
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, dialed.IsClosed())
+}
+
+func TestTransportCloseClosesEventEmitters(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	tr := newTestTransport(t, server).WithEventBus(eventbus.NewBus())
+	events := tr.events
+
+	// when
+	err := tr.Close()
+
+	// then
+	require.NoError(t, err)
+	for eventType, emitter := range events.emitters {
+		assert.Error(t, emitter.Emit(reflect.New(eventType).Elem().Interface()), "emitter of %v open", eventType)
+	}
+	assert.NoError(t, tr.Close())
+}
+
+func TestTransportCountsPeerConnectionOfUnregisteredConnectionOnce(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	metrics := newRecordingMetricsSink()
+	dialing := newTestTransport(t, server).WithMetricsSink(metrics)
+	defer dialing.Close()
+
+	listener, err := listening.Listen(server.signalMultiaddr())
+	require.NoError(t, err)
+	go func() {
+		accepted, err := listener.Accept()
+		if err == nil {
+			defer accepted.Close()
+			_, _ = accepted.AcceptStream() // returns once the test closes the transports
+		}
+	}()
+
+	// the handshake succeeds, but the connection can't be registered anymore
+	dialing.mConnections.Lock()
+	dialing.connectionsClosed = true
+	dialing.mConnections.Unlock()
+
+	// when
+	for {
+		_, err = dialing.Dial(context.Background(), server.signalMultiaddr(), listening.peerID)
+		if !errors.Is(err, ErrPeerNotPresent) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// then
+	assert.True(t, errors.Is(err, ErrTransportClosed), "unexpected error: %v", err)
+	assert.Equal(t, float64(0), metrics.value(MetricPeerConnectionsActive))
+}
+
+func TestTransportDrainWaitsForStreams(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	stream, err := dialed.OpenStream()
+	require.NoError(t, err)
+
+	drainErrCh := make(chan error, 1)
+	go func() {
+		drainErrCh <- dialing.Drain(context.Background())
+	}()
+
+	// when
+	select {
+	case err := <-drainErrCh:
+		require.FailNow(t, "drain finished with open stream", "error: %v", err)
+	case <-time.After(3 * drainPollInterval):
+	}
+	_, err = dialed.OpenStream()
+	require.True(t, errors.Is(err, ErrTransportClosed))
+	assert.False(t, dialed.IsClosed())
+
+	err = stream.Close()
+	require.NoError(t, err)
+
+	// then
+	assert.NoError(t, <-drainErrCh)
+	assert.True(t, dialed.IsClosed())
+}
+
+func TestTransportDrainDoesntWaitForStreamsResetByRemotePeer(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+	stream, err := dialed.OpenStream()
+	require.NoError(t, err)
+	go io.Copy(ioutil.Discard, stream) // e.g. a stream handler
+
+	remoteStream, err := accepted.AcceptStream()
+	require.NoError(t, err)
+
+	// when
+	err = remoteStream.Reset()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = dialing.Drain(ctx)
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, dialed.IsClosed())
+}
+
+func TestTransportDrainTimeout(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server)
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	_, err := dialed.OpenStream()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*drainPollInterval)
+	defer cancel()
+
+	// when
+	err = dialing.Drain(ctx)
+
+	// then
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, dialed.IsClosed())
+}
+
+func TestTransportClosesIdleConnections(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithIdleTimeout(500 * time.Millisecond)
+	defer dialing.Close()
+
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	stream, err := dialed.OpenStream()
+	require.NoError(t, err)
+
+	// when
+	time.Sleep(time.Second)
+	require.False(t, dialed.IsClosed(), "connection with open stream closed")
+	err = stream.Close()
+	require.NoError(t, err)
+
+	// then
+	require.Eventually(t, dialed.IsClosed, 5*time.Second, 10*time.Millisecond)
+	_, err = dialed.OpenStream()
+	assert.True(t, errors.Is(err, ErrConnectionIdle), "unexpected error: %v", err)
+}
+
+func TestTransportKeepsAnsweringPeersOpen(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithKeepalive(KeepaliveConfiguration{
+		Interval: 100 * time.Millisecond,
+		Timeout:  300 * time.Millisecond,
+	})
+	defer dialing.Close()
+
+	accepted, dialed := connectTestTransports(t, server, listening, dialing)
+	_, err := dialed.OpenStream()
+	require.NoError(t, err)
+	_, err = accepted.AcceptStream()
+	require.NoError(t, err)
+
+	// when
+	time.Sleep(time.Second)
+
+	// then
+	assert.False(t, dialed.IsClosed())
+}
+
+func TestTransportClosesUnresponsivePeers(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithKeepalive(KeepaliveConfiguration{
+		Interval: 100 * time.Millisecond,
+		Timeout:  300 * time.Millisecond,
+	})
+	defer dialing.Close()
+
+	// the accepted connection is never read, so keepalives aren't answered
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+
+	// when
+	_, err := dialed.OpenStream()
+	require.NoError(t, err)
+
+	// then
+	require.Eventually(t, dialed.IsClosed, 5*time.Second, 10*time.Millisecond)
+	_, err = dialed.OpenStream()
+	assert.True(t, errors.Is(err, ErrPeerUnresponsive), "unexpected error: %v", err)
+}
+
+func TestTransportDoesntPingPeersWithoutKeepalives(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening := newTestTransport(t, server)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithKeepalive(KeepaliveConfiguration{
+		Interval: 100 * time.Millisecond,
+		Timeout:  300 * time.Millisecond,
+	})
+	defer dialing.Close()
+
+	// the accepted connection is never read, as in TestTransportClosesUnresponsivePeers
+	_, dialed := connectTestTransports(t, server, listening, dialing)
+	dialed.(*connection).configuration.stream.keepalive = false // e.g. js-libp2p-webrtc-star
+
+	// when
+	_, err := dialed.OpenStream()
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+
+	// then
+	assert.False(t, dialed.IsClosed())
+}
+
+func TestTransportJoinOnListenJoinsOnFirstDial(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	signalConfiguration := server.signalConfiguration()
+	signalConfiguration.JoinOnListen = true
+	listening := newTestTransport(t, server).WithSignalConfiguration(signalConfiguration)
+	defer listening.Close()
+	dialing := newTestTransport(t, server).WithSignalConfiguration(signalConfiguration)
+	defer dialing.Close()
+
+	// when
+	accepted, dialed := connectTestTransports(t, server, listening, dialing) // dialing never listens
+
+	// then
+	assert.Equal(t, listening.peerID, dialed.RemotePeer())
+	assert.Equal(t, dialing.peerID, accepted.RemotePeer())
+}
+
+func newTestHost(t *testing.T, server *testSignalServer) (host.Host, *Transport) {
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	peerID, err := peer.IDFromPrivateKey(privKey)
+	require.NoError(t, err)
+
+	peerstore := pstoremem.NewPeerstore()
+	starTransport := New(peerID, peerstore, sm_yamux.DefaultTransport).
+		WithSignalConfiguration(server.signalConfiguration())
+
+	h, err := libp2p.New(context.Background(),
+		libp2p.Identity(privKey),
+		libp2p.ListenAddrs(server.signalMultiaddr()),
+		libp2p.Peerstore(peerstore),
+		libp2p.Transport(starTransport),
+		libp2p.Muxer("/yamux/1.0.0", sm_yamux.DefaultTransport))
+	require.NoError(t, err)
+	return h, starTransport
+}
+
+func TestTransportLookupConn(t *testing.T) {
+	server := newTestSignalTLSServer()
+	defer server.Close()
+
+	listening, _ := newTestHost(t, server)
+	defer listening.Close()
+	dialing, dialingTransport := newTestHost(t, server)
+	defer dialing.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	addrInfo := peer.AddrInfo{ID: listening.ID(), Addrs: []ma.Multiaddr{server.signalMultiaddr()}}
+	require.Eventually(t, func() bool { // the listener may not have joined yet
+		return dialing.Connect(ctx, addrInfo) == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	conns := dialing.Network().ConnsToPeer(listening.ID())
+	require.Len(t, conns, 1)
+
+	// when
+	conn, ok := dialingTransport.LookupConn(conns[0])
+
+	// then
+	require.True(t, ok, "connection not found")
+	assert.Equal(t, listening.ID(), conn.RemotePeer())
+
+	err := conns[0].Close()
+	require.NoError(t, err)
+	_, ok = dialingTransport.LookupConn(conns[0])
+	assert.False(t, ok, "closed connection found")
+}